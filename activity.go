@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// activityOptions holds the optional behaviour of the activity command
+type activityOptions struct {
+	Since    time.Time
+	Root     string
+	EventDB  string
+	EventLog string
+	ByUser   bool
+	Limit    int
+	JSON     bool
+}
+
+// activityCounts is the activity of one kind of change
+type activityCounts struct {
+	Events int   `json:"events"`
+	Files  int   `json:"files"`
+	Bytes  int64 `json:"bytes"`
+
+	// sizes holds the largest size seen per file, so that a file written
+	// in many steps counts once
+	sizes map[string]int64
+}
+
+// add counts an event
+func (c *activityCounts) add(event Event) {
+	if c.sizes == nil {
+		c.sizes = make(map[string]int64)
+	}
+	c.Events++
+	if size, ok := c.sizes[event.Path]; !ok || event.Size > size {
+		c.sizes[event.Path] = event.Size
+	}
+}
+
+// finish computes the file and byte totals
+func (c *activityCounts) finish() {
+	c.Files = len(c.sizes)
+	c.Bytes = 0
+	for _, size := range c.sizes {
+		c.Bytes += size
+	}
+}
+
+// String formats the counts for the table
+func (c activityCounts) String() string {
+	if c.Events == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d / %s", c.Files, formatSize(c.Bytes))
+}
+
+// activityRow is the activity of one user and process, or one directory
+type activityRow struct {
+	User      string         `json:"user,omitempty"`
+	Process   string         `json:"process,omitempty"`
+	Root      string         `json:"root,omitempty"`
+	Created   activityCounts `json:"created"`
+	Modified  activityCounts `json:"modified"`
+	Deleted   activityCounts `json:"deleted"`
+	Renamed   activityCounts `json:"renamed"`
+	Events    int            `json:"events"`
+	Bytes     int64          `json:"bytes"`
+	FirstSeen time.Time      `json:"first_seen"`
+	LastSeen  time.Time      `json:"last_seen"`
+}
+
+// loadActivityEvents reads the events of the period from the event log or
+// database
+func loadActivityEvents(opts activityOptions) ([]Event, error) {
+	if opts.EventLog != "" {
+		file, err := os.Open(opts.EventLog)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		var events []Event
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var event Event
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Path == "" {
+				continue
+			}
+			if event.Time.Before(opts.Since) || opts.Root != "" && event.Root != opts.Root {
+				continue
+			}
+			events = append(events, event)
+		}
+		return events, scanner.Err()
+	}
+
+	if _, err := os.Stat(opts.EventDB); err != nil {
+		return nil, fmt.Errorf("no event database at %s, record events with --event-db or use --event-log", opts.EventDB)
+	}
+	db, err := openEventDB(opts.EventDB)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return listActivityEvents(db, opts)
+}
+
+// listActivityEvents queries the events of the period, oldest first
+func listActivityEvents(db *sql.DB, opts activityOptions) ([]Event, error) {
+	events, err := listEvents(db, eventFilter{Since: opts.Since, Root: opts.Root})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// summarizeActivity groups events by user and process, or by monitored
+// directory, busiest first
+func summarizeActivity(events []Event, byUser bool) []activityRow {
+	rows := make(map[string]*activityRow)
+	for _, event := range events {
+		key := event.Root
+		if byUser {
+			key = event.User + "\x00" + event.Process
+		}
+
+		row, ok := rows[key]
+		if !ok {
+			row = &activityRow{FirstSeen: event.Time}
+			if byUser {
+				row.User, row.Process = event.User, event.Process
+			} else {
+				row.Root = event.Root
+			}
+			rows[key] = row
+		}
+
+		row.Events++
+		if event.Time.Before(row.FirstSeen) {
+			row.FirstSeen = event.Time
+		}
+		if event.Time.After(row.LastSeen) {
+			row.LastSeen = event.Time
+		}
+		switch event.Op {
+		case "CREATED":
+			row.Created.add(event)
+		case "MODIFIED":
+			row.Modified.add(event)
+		case "DELETED":
+			row.Deleted.add(event)
+		case "RENAMED":
+			row.Renamed.add(event)
+		}
+	}
+
+	list := make([]activityRow, 0, len(rows))
+	for _, row := range rows {
+		for _, counts := range []*activityCounts{&row.Created, &row.Modified, &row.Deleted, &row.Renamed} {
+			counts.finish()
+		}
+		row.Bytes = row.Created.Bytes + row.Modified.Bytes + row.Deleted.Bytes
+		list = append(list, *row)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+		return list[i].Events > list[j].Events
+	})
+	return list
+}
+
+// showActivity prints who (or which directory) created, modified and
+// deleted the most data in the period
+func showActivity(opts activityOptions) error {
+	if opts.Root != "" {
+		root, err := filepath.Abs(opts.Root)
+		if err != nil {
+			return err
+		}
+		opts.Root = root
+	}
+
+	events, err := loadActivityEvents(opts)
+	if err != nil {
+		return err
+	}
+
+	rows := summarizeActivity(events, opts.ByUser)
+	if opts.Limit > 0 && len(rows) > opts.Limit {
+		rows = rows[:opts.Limit]
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	where := "all monitored directories"
+	if opts.Root != "" {
+		where = opts.Root
+	}
+	fmt.Printf("Activity in %s since %s (%d events)\n", where, opts.Since.Format("2006-01-02 15:04"), len(events))
+	if len(rows) == 0 {
+		fmt.Println("No events in this period")
+		return nil
+	}
+
+	fmt.Println("Changes are shown as files / data; files changed repeatedly count once, at their largest size.")
+	fmt.Println(strings.Repeat("-", 120))
+	if opts.ByUser {
+		fmt.Printf("%-12s %-16s %-20s %-20s %-20s %-8s %s\n", "USER", "PROCESS", "CREATED", "MODIFIED", "DELETED", "EVENTS", "LAST")
+	} else {
+		fmt.Printf("%-29s %-20s %-20s %-20s %-8s %s\n", "DIRECTORY", "CREATED", "MODIFIED", "DELETED", "EVENTS", "LAST")
+	}
+	fmt.Println(strings.Repeat("-", 120))
+
+	unattributed := 0
+	for _, row := range rows {
+		if opts.ByUser {
+			user, process := row.User, row.Process
+			if user == "" {
+				user = "unknown"
+			}
+			if process == "" {
+				process = "unknown"
+			}
+			if row.User == "" && row.Process == "" {
+				unattributed += row.Events
+			}
+			fmt.Printf("%-12s %-16s %-20s %-20s %-20s %-8d %s\n", truncateString(user, 12), truncateString(process, 16),
+				row.Created, row.Modified, row.Deleted, row.Events, row.LastSeen.Local().Format("2006-01-02 15:04"))
+		} else {
+			fmt.Printf("%-29s %-20s %-20s %-20s %-8d %s\n", truncateString(row.Root, 29),
+				row.Created, row.Modified, row.Deleted, row.Events, row.LastSeen.Local().Format("2006-01-02 15:04"))
+		}
+	}
+
+	if unattributed > 0 {
+		fmt.Printf("\n%d events have no user, record them with monitor --attribute (Linux, as root) to attribute them.\n", unattributed)
+	}
+	return nil
+}
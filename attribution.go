@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// attributionWait is how long an event waits for fanotify to report who
+// caused it, since fanotify and inotify events are read independently
+const attributionWait = 100 * time.Millisecond
+
+// attributionTTL is how long an attribution no event claimed is kept
+const attributionTTL = 10 * time.Second
+
+// processInfo identifies who made a change
+type processInfo struct {
+	User    string
+	Process string
+}
+
+// attributionQueueSize is how many events may wait for their attribution
+// before the event loop blocks
+const attributionQueueSize = 4096
+
+// attributionEntry is a reported change waiting for its watcher event
+type attributionEntry struct {
+	info processInfo
+	time time.Time
+}
+
+// attributionCache matches the changes fanotify attributes to processes
+// with the events of the directory watcher, by path and event type. Each
+// reported change is used by one event at most, oldest first, so a change
+// is never credited to whoever changed the same file before.
+type attributionCache struct {
+	mu      sync.Mutex
+	entries map[string][]attributionEntry
+
+	// queue holds events waiting for their attribution, handled in order
+	// off the event loop
+	queue chan attributedEvent
+	done  chan struct{}
+}
+
+// attributedEvent is an event waiting for its attribution and what to do
+// with it once attributed
+type attributedEvent struct {
+	event  Event
+	handle func(Event)
+}
+
+// newAttributionCache creates an empty attribution cache
+func newAttributionCache() *attributionCache {
+	return &attributionCache{entries: make(map[string][]attributionEntry)}
+}
+
+// attributionKey returns the cache key of a change
+func attributionKey(path, op string) string {
+	return op + "\x00" + path
+}
+
+// Add remembers who made a change, after any earlier changes of the same
+// kind to the same file that no event claimed yet
+func (c *attributionCache) Add(path, op string, info processInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) > 10000 {
+		for key, entries := range c.entries {
+			if now.Sub(entries[len(entries)-1].time) > attributionTTL {
+				delete(c.entries, key)
+			}
+		}
+	}
+	key := attributionKey(path, op)
+	c.entries[key] = append(c.entries[key], attributionEntry{info: info, time: now})
+}
+
+// claim removes and returns the oldest unclaimed attribution of a change
+func (c *attributionCache) claim(key string) (processInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := c.entries[key]
+	for len(entries) > 0 && time.Since(entries[0].time) > attributionTTL {
+		entries = entries[1:]
+	}
+	if len(entries) == 0 {
+		delete(c.entries, key)
+		return processInfo{}, false
+	}
+
+	info := entries[0].info
+	if len(entries) == 1 {
+		delete(c.entries, key)
+	} else {
+		c.entries[key] = entries[1:]
+	}
+	return info, true
+}
+
+// Attribute fills in the user and process of an event, waiting until
+// attributionWait after the event for its attribution to arrive. Fanotify
+// merges repeated changes to a file that are still queued, so some events
+// have none and are left unattributed.
+func (c *attributionCache) Attribute(event *Event) {
+	key := attributionKey(event.Path, event.Op)
+	deadline := event.Time.Add(attributionWait)
+	for {
+		if info, ok := c.claim(key); ok {
+			event.User = info.User
+			event.Process = info.Process
+			return
+		}
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Deliver attributes an event and passes it to handle. Events are queued
+// and handled in order on a separate goroutine, so that waiting for an
+// attribution never holds up the event loop. On a nil cache the event is
+// handled right away.
+func (c *attributionCache) Deliver(event Event, handle func(Event)) {
+	if c == nil {
+		handle(event)
+		return
+	}
+	c.queue <- attributedEvent{event: event, handle: handle}
+}
+
+// run attributes and handles queued events until the queue is closed
+func (c *attributionCache) run() {
+	defer close(c.done)
+	for queued := range c.queue {
+		c.Attribute(&queued.event)
+		queued.handle(queued.event)
+	}
+}
+
+// describeAttribution returns the " by user (process)" suffix printed for
+// attributed events
+func describeAttribution(event Event) string {
+	switch {
+	case event.User != "" && event.Process != "":
+		return " by " + event.User + " (" + event.Process + ")"
+	case event.User != "":
+		return " by " + event.User
+	case event.Process != "":
+		return " by " + event.Process
+	}
+	return ""
+}
+
+// newAttribution starts attributing changes below roots when the monitor
+// options ask for it. Otherwise the returned cache is nil, which Deliver
+// accepts. The returned function handles the events still queued, then
+// stops attributing; call it once the event loop has stopped.
+func newAttribution(roots []string, opts monitorOptions) (*attributionCache, func(), error) {
+	if !opts.Attribute {
+		return nil, func() {}, nil
+	}
+
+	cache := newAttributionCache()
+	stop, err := startAttribution(roots, cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Println("Attributing changes to users and processes with fanotify")
+
+	cache.queue = make(chan attributedEvent, attributionQueueSize)
+	cache.done = make(chan struct{})
+	go cache.run()
+	return cache, func() {
+		close(cache.queue)
+		<-cache.done
+		stop()
+	}, nil
+}
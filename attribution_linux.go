@@ -0,0 +1,237 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fanotifyMetadataSize is the size of struct fanotify_event_metadata
+const fanotifyMetadataSize = 24
+
+// fanotifyMask selects the changes to the entries of a marked directory
+const fanotifyMask = unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_MOVED_FROM | unix.FAN_MOVED_TO |
+	unix.FAN_MODIFY | unix.FAN_ATTRIB | unix.FAN_ONDIR | unix.FAN_EVENT_ON_CHILD
+
+// fanotifyOps maps fanotify event bits to the event types of the monitor,
+// following how inotify events are named
+var fanotifyOps = []struct {
+	mask uint64
+	op   string
+}{
+	{unix.FAN_CREATE, "CREATED"},
+	{unix.FAN_MOVED_TO, "CREATED"},
+	{unix.FAN_DELETE, "DELETED"},
+	{unix.FAN_MOVED_FROM, "RENAMED"},
+	{unix.FAN_MODIFY, "MODIFIED"},
+	{unix.FAN_ATTRIB, "CHMOD"},
+}
+
+// startAttribution marks the roots with fanotify and adds the user and
+// process behind every change below them to cache, until stop is called.
+// Unlike inotify, fanotify reports the process that made a change, but
+// only to root.
+func startAttribution(roots []string, cache *attributionCache) (func(), error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|unix.FAN_REPORT_DFID_NAME,
+		unix.O_RDONLY|unix.O_LARGEFILE)
+	switch {
+	case errors.Is(err, unix.EPERM):
+		return nil, fmt.Errorf("--attribute needs root (CAP_SYS_ADMIN) to see which processes change files")
+	case errors.Is(err, unix.EINVAL):
+		return nil, fmt.Errorf("--attribute needs Linux 5.9 or later")
+	case err != nil:
+		return nil, fmt.Errorf("starting fanotify: %v", err)
+	}
+
+	// Events name the directory by its file handle
+	dirs := make(map[string]string)
+	for _, root := range roots {
+		key, err := dirHandleKey(root)
+		if err == nil {
+			err = unix.FanotifyMark(fd, unix.FAN_MARK_ADD, fanotifyMask, unix.AT_FDCWD, root)
+		}
+		if err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("attributing changes in %s: %v", root, err)
+		}
+		dirs[key] = root
+	}
+
+	file := os.NewFile(uintptr(fd), "fanotify")
+	go readFanotify(file, dirs, cache)
+	return func() { file.Close() }, nil
+}
+
+// handleKey identifies a file by filesystem ID and file handle
+func handleKey(fsid [2]int32, handleType int32, handle []byte) string {
+	return fmt.Sprintf("%08x%08x.%d.%x", uint32(fsid[0]), uint32(fsid[1]), handleType, handle)
+}
+
+// dirHandleKey returns the handle key of a directory, as fanotify reports it
+func dirHandleKey(dir string) (string, error) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, dir, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return "", err
+	}
+	return handleKey(stat.Fsid.Val, handle.Type(), handle.Bytes()), nil
+}
+
+// readFanotify reads events until the fanotify file is closed. Each event
+// is a struct fanotify_event_metadata followed by info records; with
+// FAN_REPORT_DFID_NAME one of them holds the directory's handle and the
+// name of the changed entry.
+func readFanotify(file *os.File, dirs map[string]string, cache *attributionCache) {
+	processes := newProcessResolver()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				fmt.Printf("[ERROR] reading fanotify events: %v\n", err)
+			}
+			return
+		}
+
+		for data := buf[:n]; len(data) >= fanotifyMetadataSize; {
+			eventLen := int(binary.NativeEndian.Uint32(data[0:]))
+			if eventLen < fanotifyMetadataSize || eventLen > len(data) {
+				break
+			}
+			event := data[:eventLen]
+			data = data[eventLen:]
+
+			if event[4] != unix.FANOTIFY_METADATA_VERSION {
+				continue
+			}
+			metadataLen := int(binary.NativeEndian.Uint16(event[6:]))
+			mask := binary.NativeEndian.Uint64(event[8:])
+			eventFd := int32(binary.NativeEndian.Uint32(event[16:]))
+			pid := int(int32(binary.NativeEndian.Uint32(event[20:])))
+			if eventFd >= 0 {
+				unix.Close(int(eventFd))
+			}
+
+			if mask&unix.FAN_Q_OVERFLOW != 0 {
+				fmt.Println("[ERROR] fanotify queue overflowed, some changes could not be attributed")
+				continue
+			}
+			if metadataLen > len(event) {
+				continue
+			}
+
+			path, ok := fanotifyPath(event[metadataLen:], dirs)
+			if !ok {
+				continue
+			}
+			info := processes.Resolve(pid)
+			for _, op := range fanotifyOps {
+				if mask&op.mask != 0 {
+					cache.Add(path, op.op, info)
+				}
+			}
+		}
+	}
+}
+
+// fanotifyPath returns the path named by an event's directory handle and
+// name record, if the directory is one of the marked roots
+func fanotifyPath(info []byte, dirs map[string]string) (string, bool) {
+	for len(info) >= 4 {
+		infoType := info[0]
+		infoLen := int(binary.NativeEndian.Uint16(info[2:]))
+		if infoLen < 4 || infoLen > len(info) {
+			return "", false
+		}
+		record := info[:infoLen]
+		info = info[infoLen:]
+
+		// Header, fsid, then struct file_handle and the name
+		if infoType != unix.FAN_EVENT_INFO_TYPE_DFID_NAME || len(record) < 20 {
+			continue
+		}
+		fsid := [2]int32{
+			int32(binary.NativeEndian.Uint32(record[4:])),
+			int32(binary.NativeEndian.Uint32(record[8:])),
+		}
+		handleBytes := int(binary.NativeEndian.Uint32(record[12:]))
+		handleType := int32(binary.NativeEndian.Uint32(record[16:]))
+		if 20+handleBytes > len(record) {
+			continue
+		}
+		handle := record[20 : 20+handleBytes]
+		name := record[20+handleBytes:]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+
+		dir, ok := dirs[handleKey(fsid, handleType, handle)]
+		if !ok || len(name) == 0 || string(name) == "." {
+			continue
+		}
+		return filepath.Join(dir, string(name)), true
+	}
+	return "", false
+}
+
+// processResolver looks up the user and command of processes, caching user
+// names
+type processResolver struct {
+	users map[string]string
+}
+
+func newProcessResolver() *processResolver {
+	return &processResolver{users: make(map[string]string)}
+}
+
+// Resolve returns who runs a process. Short-lived processes may have exited
+// by the time their event is read, leaving only the PID.
+func (r *processResolver) Resolve(pid int) processInfo {
+	info := processInfo{Process: "pid " + strconv.Itoa(pid)}
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	if comm, err := os.ReadFile(filepath.Join(procDir, "comm")); err == nil {
+		info.Process = strings.TrimSpace(string(comm))
+	}
+
+	status, err := os.ReadFile(filepath.Join(procDir, "status"))
+	if err != nil {
+		return info
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		// Uid: real, effective, saved and filesystem UID
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "Uid:" {
+			info.User = r.userName(fields[2])
+			break
+		}
+	}
+	return info
+}
+
+// userName returns the name of a UID, or the UID if it has none
+func (r *processResolver) userName(uid string) string {
+	if name, ok := r.users[uid]; ok {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	r.users[uid] = name
+	return name
+}
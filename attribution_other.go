@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// startAttribution is only available on Linux, which has fanotify
+func startAttribution(roots []string, cache *attributionCache) (func(), error) {
+	return nil, fmt.Errorf("--attribute needs fanotify, which is only available on Linux")
+}
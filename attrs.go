@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileAttrs is the file metadata compared when a CHMOD event fires
+type fileAttrs struct {
+	Mode     os.FileMode
+	UID      int
+	GID      int
+	HasOwner bool
+	Size     int64
+}
+
+// statAttrs reads the attributes of a file without following symlinks
+func statAttrs(path string) (fileAttrs, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fileAttrs{}, err
+	}
+
+	attrs := fileAttrs{
+		Mode: info.Mode(),
+		Size: info.Size(),
+	}
+	attrs.UID, attrs.GID, attrs.HasOwner = fileOwner(info)
+	return attrs, nil
+}
+
+// owner formats the owner as user:group, using names where they resolve
+func (a fileAttrs) owner() string {
+	if !a.HasOwner {
+		return "unknown"
+	}
+
+	userName := strconv.Itoa(a.UID)
+	if u, err := user.LookupId(userName); err == nil {
+		userName = u.Username
+	}
+	groupName := strconv.Itoa(a.GID)
+	if g, err := user.LookupGroupId(groupName); err == nil {
+		groupName = g.Name
+	}
+	return userName + ":" + groupName
+}
+
+// attrCache remembers the last known attributes of watched files so CHMOD
+// events can report what actually changed
+type attrCache struct {
+	mu    sync.Mutex
+	attrs map[string]fileAttrs
+}
+
+// newAttrCache creates an empty attribute cache
+func newAttrCache() *attrCache {
+	return &attrCache{attrs: make(map[string]fileAttrs)}
+}
+
+// Prime caches the attributes of a directory and its direct entries
+func (c *attrCache) Prime(dir string) {
+	c.update(dir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		c.update(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// Observe keeps the cache in sync with an event and, for CHMOD events,
+// returns a description of the attribute change. It also returns the size
+// of a regular file: its current size, or the last known one for files
+// that were deleted or renamed.
+func (c *attrCache) Observe(event fsnotify.Event) (string, int64) {
+	switch eventTypeName(event.Op) {
+	case "CREATED", "MODIFIED":
+		c.update(event.Name)
+	case "DELETED", "RENAMED":
+		c.mu.Lock()
+		attrs, ok := c.attrs[event.Name]
+		delete(c.attrs, event.Name)
+		c.mu.Unlock()
+		if ok && attrs.Mode.IsRegular() {
+			return "", attrs.Size
+		}
+		return "", 0
+	case "CHMOD":
+		return c.describeChange(event.Name), c.size(event.Name)
+	}
+	return "", c.size(event.Name)
+}
+
+// size returns the cached size of a regular file, or 0
+func (c *attrCache) size(path string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if attrs, ok := c.attrs[path]; ok && attrs.Mode.IsRegular() {
+		return attrs.Size
+	}
+	return 0
+}
+
+// update stores the current attributes of path
+func (c *attrCache) update(path string) {
+	attrs, err := statAttrs(path)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.attrs[path] = attrs
+	c.mu.Unlock()
+}
+
+// describeChange compares the current attributes of path with the cached
+// ones and updates the cache
+func (c *attrCache) describeChange(path string) string {
+	current, err := statAttrs(path)
+	if os.IsNotExist(err) {
+		return "" // Already removed again, e.g. an editor's temporary file
+	}
+	if err != nil {
+		return fmt.Sprintf("could not stat: %v", err)
+	}
+
+	c.mu.Lock()
+	previous, cached := c.attrs[path]
+	c.attrs[path] = current
+	c.mu.Unlock()
+
+	if !cached {
+		return fmt.Sprintf("mode %s, owner %s, size %s",
+			current.Mode, current.owner(), formatSize(current.Size))
+	}
+
+	var changes []string
+	if previous.Mode != current.Mode {
+		changes = append(changes, fmt.Sprintf("mode %s -> %s", previous.Mode, current.Mode))
+	}
+	if previous.HasOwner && (previous.UID != current.UID || previous.GID != current.GID) {
+		changes = append(changes, fmt.Sprintf("owner %s -> %s", previous.owner(), current.owner()))
+	}
+	if previous.Size != current.Size {
+		changes = append(changes, fmt.Sprintf("size %s -> %s",
+			formatSize(previous.Size), formatSize(current.Size)))
+	}
+
+	if len(changes) == 0 {
+		return fmt.Sprintf("timestamps only; mode %s, owner %s", current.Mode, current.owner())
+	}
+	return strings.Join(changes, ", ")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// API token scopes, from least to most privileged. Each scope includes the
+// permissions of the ones before it.
+const (
+	scopeRead  = "read"  // list jobs, directories and events, run read-only queries
+	scopeScan  = "scan"  // also start and cancel scan jobs
+	scopeAdmin = "admin" // also change the monitored directories
+)
+
+// scopeLevels orders the scopes for comparison
+var scopeLevels = map[string]int{
+	scopeRead:  1,
+	scopeScan:  2,
+	scopeAdmin: 3,
+}
+
+// APIToken grants a client access to the server API. Only a hash of the
+// token is stored, so the config file doesn't contain usable credentials.
+type APIToken struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Scope   string    `json:"scope"`
+	Created time.Time `json:"created"`
+}
+
+// allows reports whether the token's scope includes the required one
+func (t APIToken) allows(scope string) bool {
+	return scopeLevels[t.Scope] >= scopeLevels[scope]
+}
+
+// hashToken returns the stored form of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// findToken returns the token matching a presented secret
+func findToken(tokens []APIToken, token string) (APIToken, bool) {
+	hash := hashToken(token)
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// bearerToken extracts the token from an Authorization header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// createToken generates a token with the given scope, saves its hash and
+// prints the token, which cannot be shown again
+func createToken(name, scope string) error {
+	if name == "" {
+		return fmt.Errorf("please specify a token name")
+	}
+	if _, ok := scopeLevels[scope]; !ok {
+		return fmt.Errorf("unknown scope %q, use read, scan or admin", scope)
+	}
+	for _, t := range appConfig.APITokens {
+		if t.Name == name {
+			return fmt.Errorf("a token named %s already exists", name)
+		}
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := "dm_" + hex.EncodeToString(secret)
+
+	appConfig.APITokens = append(appConfig.APITokens, APIToken{
+		Name:    name,
+		Hash:    hashToken(token),
+		Scope:   scope,
+		Created: time.Now(),
+	})
+	if err := saveConfig(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s token %s:\n\n  %s\n\n", scope, name, token)
+	fmt.Println("Store it now, it cannot be shown again. Restart running servers to pick it up.")
+	return nil
+}
+
+// listTokens prints the configured tokens without their secrets
+func listTokens() {
+	if len(appConfig.APITokens) == 0 {
+		fmt.Println("No API tokens configured, the server API is open to anyone who can reach it")
+		return
+	}
+
+	fmt.Printf("%-20s %-8s %s\n", "NAME", "SCOPE", "CREATED")
+	fmt.Println(strings.Repeat("-", 50))
+	for _, t := range appConfig.APITokens {
+		fmt.Printf("%-20s %-8s %s\n", t.Name, t.Scope, t.Created.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// revokeToken removes a token by name
+func revokeToken(name string) error {
+	for i, t := range appConfig.APITokens {
+		if t.Name == name {
+			appConfig.APITokens = append(appConfig.APITokens[:i], appConfig.APITokens[i+1:]...)
+			if err := saveConfig(); err != nil {
+				return err
+			}
+			fmt.Printf("Token %s has been revoked. Restart running servers to apply.\n", name)
+			return nil
+		}
+	}
+	return fmt.Errorf("no token named %s", name)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// checkpointInterval is how often long-running scans persist their progress
+const checkpointInterval = 30 * time.Second
+
+// hashEntry is a cached content hash, valid while size and mtime are unchanged
+type hashEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"`
+}
+
+// duplicateCheckpoint records the progress of a find-duplicates scan so an
+// interrupted scan can be resumed with --resume
+type duplicateCheckpoint struct {
+	Root        string               `json:"root"`
+	Updated     time.Time            `json:"updated"`
+	LastWalked  string               `json:"last_walked"`
+	WalkDone    bool                 `json:"walk_done"`
+	FilesBySize map[int64][]string   `json:"files_by_size"`
+	Hashes      map[string]hashEntry `json:"hashes"`
+
+	key       string
+	lastSaved time.Time
+}
+
+// newDuplicateCheckpoint creates an empty checkpoint for a scan root
+func newDuplicateCheckpoint(root string) *duplicateCheckpoint {
+	return &duplicateCheckpoint{
+		Root:        root,
+		FilesBySize: make(map[int64][]string),
+		Hashes:      make(map[string]hashEntry),
+		key:         checkpointKey("duplicates", root),
+		lastSaved:   time.Now(),
+	}
+}
+
+// checkpointKey returns the key of the checkpoint used for a scan root
+func checkpointKey(kind, root string) string {
+	sum := md5.Sum([]byte(root))
+	return kind + "-" + hex.EncodeToString(sum[:6]) + ".json"
+}
+
+// loadDuplicateCheckpoint reads a previously saved checkpoint for root
+func loadDuplicateCheckpoint(root string) (*duplicateCheckpoint, error) {
+	data, err := state.Get("checkpoints", checkpointKey("duplicates", root))
+	if err != nil {
+		return nil, err
+	}
+
+	cp := newDuplicateCheckpoint(root)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// save stores the checkpoint, replacing the previous one atomically so an
+// interrupt never leaves a truncated checkpoint behind
+func (cp *duplicateCheckpoint) save() error {
+	cp.Updated = time.Now()
+	cp.lastSaved = cp.Updated
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return state.Put("checkpoints", cp.key, data)
+}
+
+// saveIfDue saves the checkpoint when checkpointInterval has elapsed
+func (cp *duplicateCheckpoint) saveIfDue() error {
+	if time.Since(cp.lastSaved) < checkpointInterval {
+		return nil
+	}
+	return cp.save()
+}
+
+// remove deletes the checkpoint once the scan has completed
+func (cp *duplicateCheckpoint) remove() {
+	state.Delete("checkpoints", cp.key)
+}
+
+// walkedBefore reports whether filepath.Walk visits a no later than b.
+// Walk goes through names in lexical order per directory, so paths have to
+// be compared element by element rather than as plain strings.
+func walkedBefore(a, b string) bool {
+	aParts := strings.Split(a, string(filepath.Separator))
+	bParts := strings.Split(b, string(filepath.Separator))
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if aParts[i] != bParts[i] {
+			return aParts[i] < bParts[i]
+		}
+	}
+	return len(aParts) <= len(bParts)
+}
@@ -0,0 +1,288 @@
+// Package client is a Go client for the dirmon server API, as started with
+// "dirmon serve".
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to a dirmon server
+type Client struct {
+	// BaseURL is the server's address, e.g. http://127.0.0.1:8080
+	BaseURL string
+
+	// Token is sent as a bearer token if set, for servers that require
+	// API tokens
+	Token string
+
+	// HTTPClient is used for requests; http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// New creates a client for the server at addr, given either as host:port
+// or as a full URL
+func New(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{BaseURL: strings.TrimRight(addr, "/")}
+}
+
+// Event is a filesystem change recorded by the server
+type Event struct {
+	Time   time.Time `json:"time"`
+	Root   string    `json:"root"`
+	Path   string    `json:"path"`
+	Op     string    `json:"op"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// EventFilter restricts the events returned by Events. Zero values match
+// everything.
+type EventFilter struct {
+	Since time.Time
+	Root  string
+	Op    string
+	Limit int
+}
+
+// QueryResult is the result of an SQL query against the event database
+type QueryResult struct {
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+// JobProgress describes how far a running job has come
+type JobProgress struct {
+	Phase string `json:"phase,omitempty"`
+	Done  int    `json:"done"`
+	Total int    `json:"total,omitempty"`
+}
+
+// Job is a scan running asynchronously on the server
+type Job struct {
+	ID       string      `json:"id"`
+	Type     string      `json:"type"`
+	Path     string      `json:"path"`
+	State    string      `json:"state"`
+	Progress JobProgress `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+}
+
+// Done reports whether the job has stopped, successfully or not
+func (j *Job) Done() bool {
+	return j.State == "done" || j.State == "failed" || j.State == "canceled"
+}
+
+// DuplicateGroup is a set of files with identical content, the result of a
+// find-duplicates job. Files start with the suggested original.
+type DuplicateGroup struct {
+	Hash     string   `json:"hash"`
+	Size     int64    `json:"size"`
+	Files    []string `json:"files"`
+	Original string   `json:"original"`
+	Reason   string   `json:"reason"`
+
+	// Shared lists files that are hard links or reflinks of an earlier file
+	// of the group, and so take no extra space
+	Shared []SharedCopy `json:"shared,omitempty"`
+}
+
+// SharedCopy is a file whose data is stored only once together with With
+type SharedCopy struct {
+	File string `json:"file"`
+	With string `json:"with"`
+	Kind string `json:"kind"` // "hard link" or "reflink"
+}
+
+// UsageEntry is a named size in a disk usage report
+type UsageEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// DiskUsageReport is the result of a disk-usage job
+type DiskUsageReport struct {
+	Root        string       `json:"root"`
+	TotalSize   int64        `json:"total_size"`
+	Types       []UsageEntry `json:"types"`
+	Directories []UsageEntry `json:"directories"`
+}
+
+// Error is an error returned by the server
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: apiErr.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// MonitoredDirs returns the server's monitored directories
+func (c *Client) MonitoredDirs(ctx context.Context) ([]string, error) {
+	var dirs []string
+	err := c.do(ctx, http.MethodGet, "/dirs", nil, &dirs)
+	return dirs, err
+}
+
+// AddDir adds a directory on the server to its monitored list
+func (c *Client) AddDir(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodPost, "/dirs", map[string]string{"path": path}, nil)
+}
+
+// RemoveDir removes a directory from the server's monitored list
+func (c *Client) RemoveDir(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodDelete, "/dirs?path="+url.QueryEscape(path), nil, nil)
+}
+
+// Events returns recorded events matching the filter, newest first
+func (c *Client) Events(ctx context.Context, filter EventFilter) ([]Event, error) {
+	query := url.Values{}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339))
+	}
+	if filter.Root != "" {
+		query.Set("root", filter.Root)
+	}
+	if filter.Op != "" {
+		query.Set("op", filter.Op)
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	var events []Event
+	err := c.do(ctx, http.MethodGet, "/events?"+query.Encode(), nil, &events)
+	return events, err
+}
+
+// QueryEvents runs a read-only SQL query against the server's event database
+func (c *Client) QueryEvents(ctx context.Context, query string) (*QueryResult, error) {
+	var result QueryResult
+	err := c.do(ctx, http.MethodPost, "/events/query", map[string]string{"query": query}, &result)
+	return &result, err
+}
+
+// StartJob starts a scan job of the given type ("find-duplicates" or
+// "disk-usage") for a directory on the server
+func (c *Client) StartJob(ctx context.Context, jobType, path string) (*Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodPost, "/jobs", map[string]string{"type": jobType, "path": path}, &job)
+	return &job, err
+}
+
+// Jobs lists the server's jobs, newest first
+func (c *Client) Jobs(ctx context.Context) ([]Job, error) {
+	var jobs []Job
+	err := c.do(ctx, http.MethodGet, "/jobs", nil, &jobs)
+	return jobs, err
+}
+
+// Job returns the current state of a job
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &job)
+	return &job, err
+}
+
+// CancelJob cancels a queued or running job
+func (c *Client) CancelJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(id), nil, &job)
+	return &job, err
+}
+
+// JobResult decodes the result of a finished job into out, which should be
+// a *[]DuplicateGroup or *DiskUsageReport depending on the job type
+func (c *Client) JobResult(ctx context.Context, id string, out any) error {
+	return c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/result", nil, out)
+}
+
+// WaitJob polls a job until it has finished, calling onProgress (if not
+// nil) after every poll
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration, onProgress func(*Job)) (*Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if onProgress != nil {
+			onProgress(job)
+		}
+		if job.Done() {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
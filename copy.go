@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// copyBufferSize is the chunk size of the copy engine, and how often
+// progress is reported
+const copyBufferSize = 256 * 1024
+
+// copyOptions holds the optional behaviour of the copy engine
+type copyOptions struct {
+	// BytesPerSecond limits the copy rate; 0 means no limit
+	BytesPerSecond int64
+
+	// Progress, if set, is called after every chunk with the bytes copied
+	// so far and the total
+	Progress func(copied, total int64)
+}
+
+// parseByteRate parses a bandwidth limit in bytes per second, such as
+// "512K", "10MB" or "1.5G". Units are powers of 1024, like formatSize.
+func parseByteRate(rate string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(rate))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "B")
+
+	multiplier := float64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1024
+		case 'M':
+			multiplier = 1024 * 1024
+		case 'G':
+			multiplier = 1024 * 1024 * 1024
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid bandwidth limit %q, use e.g. 512K or 10MB", rate)
+	}
+	return int64(value * multiplier), nil
+}
+
+// rateLimitedReader reads no faster than rate bytes per second on average
+type rateLimitedReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+
+	// Keep chunks small enough that slow limits are still smooth
+	if chunk := l.rate/4 + 1; int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	due := time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second))
+	if wait := due - time.Since(l.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// copyStream copies total bytes from src to dst, applying the bandwidth
+// limit and reporting progress, and returns the SHA-256 of the data
+func copyStream(dst io.Writer, src io.Reader, total int64, opts copyOptions) (string, error) {
+	if opts.BytesPerSecond > 0 {
+		src = &rateLimitedReader{r: src, rate: opts.BytesPerSecond}
+	}
+
+	hash := sha256.New()
+	writer := io.MultiWriter(dst, hash)
+	buf := make([]byte, copyBufferSize)
+
+	var copied int64
+	for copied < total {
+		chunk := buf
+		if remaining := total - copied; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		n, err := io.ReadFull(src, chunk)
+		if n > 0 {
+			if _, err := writer.Write(chunk[:n]); err != nil {
+				return "", err
+			}
+			copied += int64(n)
+			if opts.Progress != nil {
+				opts.Progress(copied, total)
+			}
+		}
+		if err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return "", fmt.Errorf("file shrank while copying (%d of %d bytes)", copied, total)
+			}
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileSHA256 returns the SHA-256 of a file's content
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyFile copies a regular file to dst, which must not exist yet. The copy
+// is written to a temporary file next to dst, read back to verify its
+// checksum, given the permissions and timestamps of the source, and only
+// then renamed into place, so dst never holds a partial copy.
+func copyFile(src, dst string, opts copyOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(dst), ".dirmon-copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	sum, err := copyStream(temp, in, info.Size(), opts)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if copied, err := fileSHA256(temp.Name()); err != nil {
+		return err
+	} else if copied != sum {
+		return fmt.Errorf("checksum mismatch after copying %s", src)
+	}
+
+	times := getFileTimes(src, info)
+	if err := os.Chmod(temp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(temp.Name(), times.Accessed, times.Modified); err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	return os.Rename(temp.Name(), dst)
+}
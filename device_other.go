@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// sameFilesystem reports whether two paths are on the same volume, going by
+// their volume names (e.g. drive letters on Windows)
+func sameFilesystem(a, b string) bool {
+	aAbs, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	bAbs, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(filepath.VolumeName(aAbs), filepath.VolumeName(bAbs))
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// sameFilesystem reports whether two existing paths are on the same
+// filesystem, so space freed on one is taken up by writing to the other
+func sameFilesystem(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	aStat, aOK := aInfo.Sys().(*syscall.Stat_t)
+	bStat, bOK := bInfo.Sys().(*syscall.Stat_t)
+	return aOK && bOK && aStat.Dev == bStat.Dev
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// maxDiffFileSize is the largest file kept in the diff cache
+	maxDiffFileSize = 256 * 1024
+
+	// maxDiffEdits bounds the work spent diffing heavily rewritten files
+	maxDiffEdits = 2000
+
+	// diffContext is the number of unchanged lines shown around each change
+	diffContext = 3
+)
+
+// textCache keeps copies of small text files so a unified diff can be
+// printed when they are modified
+type textCache struct {
+	mu    sync.Mutex
+	lines map[string][]string
+}
+
+// newTextCache creates an empty text cache
+func newTextCache() *textCache {
+	return &textCache{lines: make(map[string][]string)}
+}
+
+// Prime caches the small text files directly inside dir
+func (c *textCache) Prime(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		c.update(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// Observe keeps the cache in sync with an event and, when a cached file is
+// modified or replaced, returns a unified diff of the change
+func (c *textCache) Observe(event fsnotify.Event) string {
+	switch eventTypeName(event.Op) {
+	case "DELETED", "RENAMED":
+		c.mu.Lock()
+		delete(c.lines, event.Name)
+		c.mu.Unlock()
+	case "CREATED", "MODIFIED":
+		// Editors often save by renaming a new file over the old one,
+		// which shows up as CREATED for a path that is still cached
+		c.mu.Lock()
+		previous, cached := c.lines[event.Name]
+		c.mu.Unlock()
+
+		current, ok := c.update(event.Name)
+		if !cached || !ok {
+			return ""
+		}
+		return unifiedDiff(previous, current, event.Name)
+	}
+	return ""
+}
+
+// update re-reads path into the cache if it is a small text file
+func (c *textCache) update(path string) ([]string, bool) {
+	lines, ok := readTextLines(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		delete(c.lines, path)
+		return nil, false
+	}
+	c.lines[path] = lines
+	return lines, true
+}
+
+// readTextLines reads a regular file as lines, rejecting large and binary files
+func readTextLines(path string) ([]string, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxDiffFileSize {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return nil, false
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, true
+}
+
+// diffOp is one line of an edit script: ' ' keeps, '-' deletes, '+' inserts.
+// aPos and bPos are the number of lines of each side preceding the op.
+type diffOp struct {
+	kind byte
+	line string
+	aPos int
+	bPos int
+}
+
+// diffLines computes a shortest edit script between a and b using Myers'
+// algorithm. It returns false if more than maxDiffEdits edits are needed.
+func diffLines(a, b []string) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		if d > maxDiffEdits {
+			return nil, false
+		}
+
+		// Keep the part of v the backtracking step needs
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		snapshot := trace[d]
+		at := func(k int) int { return snapshot[k+d+1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x], x, y})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{'+', b[y], x, y})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', a[x], x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{' ', a[x], x, y})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// unifiedDiff renders the difference between two versions of a file in
+// unified diff format, or an empty string if they are identical
+func unifiedDiff(a, b []string, name string) string {
+	ops, ok := diffLines(a, b)
+	if !ok {
+		return fmt.Sprintf("(%s changed from %d to %d lines, too many changes to diff)\n",
+			filepath.Base(name), len(a), len(b))
+	}
+
+	var sb strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are within two contexts of each other
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i
+			} else if i-end > 2*diffContext {
+				break
+			}
+		}
+
+		from := max(start-diffContext, 0)
+		to := min(end+diffContext+1, len(ops))
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s (cached)\n+++ %s\n", name, name)
+		}
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		aStart, bStart := ops[from].aPos, ops[from].bPos
+		if aCount > 0 {
+			aStart++
+		}
+		if bCount > 0 {
+			bStart++
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+
+		for _, op := range ops[from:to] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = to
+	}
+	return sb.String()
+}
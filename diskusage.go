@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// diskUsageOptions holds the optional behaviour of the disk-usage command
+type diskUsageOptions struct {
+	AllMonitored bool
+	Workers      int
+	DedupAware   bool
+
+	// Files reports file counts instead of sizes
+	Files bool
+}
+
+const (
+	// tinyFileSize is the size below which a file is counted as tiny: it
+	// still takes a whole filesystem block and an inode
+	tinyFileSize = 4096
+
+	// manyTinyFiles is the number of tiny files directly inside a directory
+	// from which the directory is highlighted in the --files view, if they
+	// also make up at least tinyFileShare percent of its files
+	manyTinyFiles = 1000
+	tinyFileShare = 50
+)
+
+// diskUsage accumulates size statistics by file type and directory for a tree
+type diskUsage struct {
+	mu        sync.Mutex
+	root      string
+	typeStats map[string]int64
+	dirStats  map[string]int64
+	totalSize int64
+
+	// File counts, for the --files view. Directories are counted in
+	// totalDirs only, as they have no type and hold no data.
+	typeCounts map[string]int64
+	dirCounts  map[string]int64
+	dirTiny    map[string]int64
+	totalFiles int64
+	totalTiny  int64
+	totalDirs  int64
+
+	// Only tracked for --dedup-aware: files grouped by size, and the size
+	// left once redundant copies of the same content are discounted
+	filesBySize map[int64][]string
+	uniqueType  map[string]int64
+	uniqueDir   map[string]int64
+	uniqueSize  int64
+}
+
+// newDiskUsage creates empty statistics for the tree rooted at root
+func newDiskUsage(root string, opts diskUsageOptions) *diskUsage {
+	usage := &diskUsage{
+		root:      root,
+		typeStats: make(map[string]int64),
+		dirStats:  make(map[string]int64),
+
+		typeCounts: make(map[string]int64),
+		dirCounts:  make(map[string]int64),
+		dirTiny:    make(map[string]int64),
+	}
+	if opts.DedupAware {
+		usage.filesBySize = make(map[int64][]string)
+	}
+	return usage
+}
+
+// fileType returns the statistics key for a file's type
+func fileType(filePath string) string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if ext == "" {
+		ext = "[no extension]"
+	}
+	return ext
+}
+
+// addFile records a file in the statistics; safe for concurrent use
+func (u *diskUsage) addFile(filePath string, size int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Update total size
+	u.totalSize += size
+
+	// Update file type stats
+	u.typeStats[fileType(filePath)] += size
+
+	// Update directory stats (by parent directory)
+	u.dirStats[filepath.Dir(filePath)] += size
+
+	u.totalFiles++
+	u.typeCounts[fileType(filePath)]++
+	u.dirCounts[filepath.Dir(filePath)]++
+	if size < tinyFileSize {
+		u.totalTiny++
+		u.dirTiny[filepath.Dir(filePath)]++
+	}
+
+	if u.filesBySize != nil {
+		u.filesBySize[size] = append(u.filesBySize[size], filePath)
+	}
+}
+
+// addDir records a directory in the statistics; safe for concurrent use
+func (u *diskUsage) addDir() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.totalDirs++
+}
+
+// merge adds the statistics of other to u
+func (u *diskUsage) merge(other *diskUsage) {
+	u.totalSize += other.totalSize
+	for ext, size := range other.typeStats {
+		u.typeStats[ext] += size
+	}
+	for dir, size := range other.dirStats {
+		u.dirStats[dir] += size
+	}
+
+	u.totalFiles += other.totalFiles
+	u.totalTiny += other.totalTiny
+	u.totalDirs += other.totalDirs
+	for ext, count := range other.typeCounts {
+		u.typeCounts[ext] += count
+	}
+	for dir, count := range other.dirCounts {
+		u.dirCounts[dir] += count
+	}
+	for dir, count := range other.dirTiny {
+		u.dirTiny[dir] += count
+	}
+	if u.filesBySize != nil {
+		for size, files := range other.filesBySize {
+			u.filesBySize[size] = append(u.filesBySize[size], files...)
+		}
+	}
+}
+
+// computeUniqueSizes hashes files of equal size and counts each distinct
+// content once, attributing it to the copy with the lowest path. Hashes are
+// cached in hashes so that overlapping reports don't read files twice.
+func (u *diskUsage) computeUniqueSizes(hashes map[string]string) {
+	u.uniqueType = make(map[string]int64)
+	u.uniqueDir = make(map[string]int64)
+	u.uniqueSize = 0
+
+	countUnique := func(filePath string, size int64) {
+		u.uniqueSize += size
+		u.uniqueType[fileType(filePath)] += size
+		u.uniqueDir[filepath.Dir(filePath)] += size
+	}
+
+	for size, files := range u.filesBySize {
+		if len(files) == 1 || size == 0 {
+			for _, file := range files {
+				countUnique(file, size)
+			}
+			continue
+		}
+
+		sorted := append([]string(nil), files...)
+		sort.Strings(sorted)
+
+		seen := make(map[string]bool)
+		for _, file := range sorted {
+			hash, ok := hashes[file]
+			if !ok {
+				var err error
+				hash, err = calculateMD5(file)
+				if err != nil {
+					// Can't compare it, so treat it as unique content
+					countUnique(file, size)
+					continue
+				}
+				hashes[file] = hash
+			}
+
+			if !seen[hash] {
+				seen[hash] = true
+				countUnique(file, size)
+			}
+		}
+	}
+}
+
+// collectDiskUsage walks a directory tree and gathers its statistics,
+// stopping early if ctx is cancelled
+func collectDiskUsage(ctx context.Context, absPath string, opts diskUsageOptions, progress scanProgress) (*diskUsage, error) {
+	usage := newDiskUsage(absPath, opts)
+	ignore := newIgnoreMatcher(absPath)
+	scanned := 0
+
+	ctx, span := tracer.Start(ctx, "disk-usage", trace.WithAttributes(attribute.String("dirmon.root", absPath)))
+	walkedAttrs := scanAttrs("disk-usage", "walk")
+
+	err := filepath.Walk(absPath, func(filePath string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			return nil // Skip files we can't access
+		}
+
+		// Skip the root directory itself
+		if filePath == absPath {
+			return nil
+		}
+
+		if ignore.Match(filePath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			usage.addDir()
+		} else {
+			usage.addFile(filePath, info.Size())
+
+			scanned++
+			if progress != nil {
+				progress("scanning", scanned, 0)
+			}
+			scanFilesCounter.Add(ctx, 1, walkedAttrs)
+		}
+
+		return nil
+	})
+
+	span.SetAttributes(attribute.Int("dirmon.files", scanned), attribute.Int64("dirmon.total_size", usage.totalSize))
+	endSpan(span, err)
+	return usage, err
+}
+
+// scanRootsConcurrently gathers statistics for several trees at once. All
+// roots share one queue of directories read by a fixed pool of workers, so
+// at most workers directories are being scanned at any time no matter how
+// many roots are scanned or how large they are.
+func scanRootsConcurrently(roots []string, opts diskUsageOptions) []*diskUsage {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// dirTask is a directory waiting to be read, with the statistics and
+	// ignore files of its root
+	type dirTask struct {
+		usage  *diskUsage
+		ignore *ignoreMatcher
+		dir    string
+	}
+
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	var queue []dirTask
+
+	// pending counts queued directories and those being read; the scan is
+	// done when it drops to zero
+	pending := 0
+
+	push := func(task dirTask) {
+		mu.Lock()
+		queue = append(queue, task)
+		pending++
+		mu.Unlock()
+		cond.Signal()
+	}
+
+	scanDir := func(task dirTask) {
+		entries, err := os.ReadDir(task.dir)
+		if err != nil {
+			return // Skip directories we can't access
+		}
+
+		for _, entry := range entries {
+			entryPath := filepath.Join(task.dir, entry.Name())
+			if task.ignore.Match(entryPath, entry.IsDir()) {
+				continue
+			}
+
+			if entry.IsDir() {
+				task.usage.addDir()
+				push(dirTask{usage: task.usage, ignore: task.ignore, dir: entryPath})
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			task.usage.addFile(entryPath, info.Size())
+		}
+	}
+
+	results := make([]*diskUsage, len(roots))
+	for i, root := range roots {
+		results[i] = newDiskUsage(root, opts)
+		push(dirTask{usage: results[i], ignore: newIgnoreMatcher(root), dir: root})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 {
+					cond.Wait()
+				}
+				if len(queue) == 0 {
+					mu.Unlock()
+					return
+				}
+				task := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+
+				scanDir(task)
+
+				mu.Lock()
+				pending--
+				if pending == 0 {
+					cond.Broadcast()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// analyzeDiskUsage shows disk usage by file types and directories
+func analyzeDiskUsage(path string, opts diskUsageOptions) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	usage, err := collectDiskUsage(context.Background(), absPath, opts, nil)
+	if err != nil {
+		return err
+	}
+
+	if opts.DedupAware {
+		usage.computeUniqueSizes(make(map[string]string))
+	}
+
+	fmt.Printf("Disk usage analysis for: %s\n\n", absPath)
+	if opts.Files {
+		printFileCounts(usage)
+	} else {
+		printDiskUsage(usage)
+	}
+
+	return nil
+}
+
+// analyzeMonitoredDiskUsage scans all monitored directories concurrently and
+// shows a section per directory followed by a combined report
+func analyzeMonitoredDiskUsage(opts diskUsageOptions) error {
+	if len(appConfig.MonitoredDirs) == 0 {
+		return fmt.Errorf("no directories to analyze")
+	}
+
+	// Roots inside another one are covered by its scan, and scanning them
+	// as well would count their files twice in the combined report
+	nested := make(map[string]bool)
+	for _, overlap := range newWatchRoots(appConfig.MonitoredDirs).Overlaps() {
+		if !nested[overlap.inner] {
+			fmt.Printf("Skipping %s, it is inside %s\n", overlap.inner, overlap.outer)
+			nested[overlap.inner] = true
+		}
+	}
+	var roots []string
+	for _, dir := range appConfig.MonitoredDirs {
+		if !nested[dir] {
+			roots = append(roots, dir)
+		}
+	}
+	if len(nested) > 0 {
+		fmt.Println()
+	}
+
+	results := scanRootsConcurrently(roots, opts)
+
+	hashes := make(map[string]string)
+	combined := newDiskUsage("", opts)
+	for _, usage := range results {
+		if opts.DedupAware {
+			usage.computeUniqueSizes(hashes)
+		}
+
+		fmt.Printf("Disk usage analysis for: %s\n\n", usage.root)
+		if opts.Files {
+			printFileCounts(usage)
+		} else {
+			printDiskUsage(usage)
+		}
+		fmt.Println()
+
+		combined.merge(usage)
+	}
+
+	fmt.Printf("Combined disk usage for %d monitored directories:\n\n", len(results))
+	if opts.Files {
+		fmt.Printf("%-50s %-15s %s\n", "DIRECTORY", "FILES", "% OF TOTAL")
+		fmt.Println(strings.Repeat("-", 75))
+		for _, usage := range results {
+			fmt.Printf("%-50s %-15d %.1f%%\n",
+				truncateString(usage.root, 49), usage.totalFiles,
+				percentOf(usage.totalFiles, combined.totalFiles))
+		}
+		fmt.Println()
+		printFileCounts(combined)
+		return nil
+	}
+
+	fmt.Printf("%-50s %-15s %s\n", "DIRECTORY", "SIZE", "% OF TOTAL")
+	fmt.Println(strings.Repeat("-", 75))
+	for _, usage := range results {
+		fmt.Printf("%-50s %-15s %.1f%%\n",
+			truncateString(usage.root, 49), formatSize(usage.totalSize),
+			percentOf(usage.totalSize, combined.totalSize))
+	}
+	fmt.Println()
+
+	// Copies spread across different roots only show up in the combined report
+	if opts.DedupAware {
+		combined.computeUniqueSizes(hashes)
+	}
+	printDiskUsage(combined)
+
+	return nil
+}
+
+// printDiskUsage displays statistics by file type and the largest directories.
+// Directories are shown relative to the root unless the statistics combine
+// several roots.
+func printDiskUsage(usage *diskUsage) {
+	dedupAware := usage.uniqueType != nil
+
+	// Display results by file type
+	fmt.Println("Usage by file type:")
+	if dedupAware {
+		fmt.Println(strings.Repeat("-", 75))
+		fmt.Printf("%-20s %-15s %-15s %-12s %s\n", "FILE TYPE", "SIZE", "UNIQUE", "REDUNDANT", "% OF TOTAL")
+		fmt.Println(strings.Repeat("-", 75))
+	} else {
+		fmt.Println(strings.Repeat("-", 60))
+		fmt.Printf("%-20s %-15s %s\n", "FILE TYPE", "SIZE", "% OF TOTAL")
+		fmt.Println(strings.Repeat("-", 60))
+	}
+
+	for _, stat := range sortedBySize(usage.typeStats) {
+		if dedupAware {
+			unique := usage.uniqueType[stat.name]
+			fmt.Printf("%-20s %-15s %-15s %-12s %.1f%%\n",
+				stat.name, formatSize(stat.size), formatSize(unique),
+				fmt.Sprintf("%.1f%%", percentOf(stat.size-unique, stat.size)),
+				percentOf(stat.size, usage.totalSize))
+			continue
+		}
+		fmt.Printf("%-20s %-15s %.1f%%\n",
+			stat.name, formatSize(stat.size), percentOf(stat.size, usage.totalSize))
+	}
+
+	// Display results by directory
+	fmt.Println("\nLargest directories:")
+	if dedupAware {
+		fmt.Println(strings.Repeat("-", 95))
+		fmt.Printf("%-50s %-15s %-15s %s\n", "DIRECTORY", "SIZE", "UNIQUE", "REDUNDANT")
+		fmt.Println(strings.Repeat("-", 95))
+	} else {
+		fmt.Println(strings.Repeat("-", 70))
+		fmt.Printf("%-50s %-15s\n", "DIRECTORY", "SIZE")
+		fmt.Println(strings.Repeat("-", 70))
+	}
+
+	// Show top 10 directories
+	count := 0
+	for _, stat := range sortedBySize(usage.dirStats) {
+		relPath := stat.name
+		if usage.root != "" {
+			if rel, err := filepath.Rel(usage.root, stat.name); err == nil {
+				relPath = rel
+			}
+		}
+
+		if relPath == "." {
+			relPath = "[root directory]"
+		}
+
+		if dedupAware {
+			unique := usage.uniqueDir[stat.name]
+			fmt.Printf("%-50s %-15s %-15s %.1f%%\n",
+				truncateString(relPath, 49), formatSize(stat.size), formatSize(unique),
+				percentOf(stat.size-unique, stat.size))
+		} else {
+			fmt.Printf("%-50s %-15s\n",
+				truncateString(relPath, 49), formatSize(stat.size))
+		}
+
+		count++
+		if count >= 10 {
+			break
+		}
+	}
+
+	if dedupAware {
+		fmt.Println(strings.Repeat("-", 95))
+		fmt.Printf("Total size: %s\n", formatSize(usage.totalSize))
+		fmt.Printf("Unique content size: %s (%s in redundant copies, %.1f%%)\n",
+			formatSize(usage.uniqueSize), formatSize(usage.totalSize-usage.uniqueSize),
+			percentOf(usage.totalSize-usage.uniqueSize, usage.totalSize))
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 70))
+	fmt.Printf("Total size: %s\n", formatSize(usage.totalSize))
+}
+
+// manyTinyFilesIn reports whether a directory holds enough tiny files to be
+// highlighted in the --files view
+func (u *diskUsage) manyTinyFilesIn(dir string) bool {
+	tiny := u.dirTiny[dir]
+	return tiny >= manyTinyFiles && percentOf(tiny, u.dirCounts[dir]) >= tinyFileShare
+}
+
+// printFileCounts displays file counts by file type and the directories with
+// the most files, highlighting directories full of tiny files. Inodes run out
+// as often as bytes do, and many filesystems and tools slow down long before.
+func printFileCounts(usage *diskUsage) {
+	averageSize := func(size, count int64) string {
+		if count == 0 {
+			return "-"
+		}
+		return formatSize(size / count)
+	}
+
+	fmt.Println("Files by file type:")
+	fmt.Println(strings.Repeat("-", 75))
+	fmt.Printf("%-20s %-12s %-12s %-15s %s\n", "FILE TYPE", "FILES", "% OF FILES", "AVERAGE SIZE", "SIZE")
+	fmt.Println(strings.Repeat("-", 75))
+	for _, stat := range sortedBySize(usage.typeCounts) {
+		fmt.Printf("%-20s %-12d %-12s %-15s %s\n",
+			stat.name, stat.size,
+			fmt.Sprintf("%.1f%%", percentOf(stat.size, usage.totalFiles)),
+			averageSize(usage.typeStats[stat.name], stat.size),
+			formatSize(usage.typeStats[stat.name]))
+	}
+
+	fmt.Println("\nDirectories with the most files (not counting subdirectories):")
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("%-50s %-12s %-12s %-15s %s\n", "DIRECTORY", "FILES", "TINY", "AVERAGE SIZE", "NOTE")
+	fmt.Println(strings.Repeat("-", 100))
+
+	// Show the top 10 directories, and every highlighted one after them
+	highlighted := 0
+	for i, stat := range sortedBySize(usage.dirCounts) {
+		many := usage.manyTinyFilesIn(stat.name)
+		if many {
+			highlighted++
+		} else if i >= 10 {
+			continue
+		}
+
+		relPath := stat.name
+		if usage.root != "" {
+			if rel, err := filepath.Rel(usage.root, stat.name); err == nil {
+				relPath = rel
+			}
+		}
+		if relPath == "." {
+			relPath = "[root directory]"
+		}
+
+		note := ""
+		if many {
+			note = "MANY TINY FILES"
+		}
+		fmt.Printf("%-50s %-12d %-12d %-15s %s\n",
+			truncateString(relPath, 49), stat.size, usage.dirTiny[stat.name],
+			averageSize(usage.dirStats[stat.name], stat.size), note)
+	}
+
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("Total: %d files in %d directories\n", usage.totalFiles, usage.totalDirs)
+	fmt.Printf("Tiny files (under %s): %d (%.1f%% of files)\n",
+		formatSize(tinyFileSize), usage.totalTiny, percentOf(usage.totalTiny, usage.totalFiles))
+	if highlighted > 0 {
+		fmt.Printf("%d directories hold %d or more tiny files; consider packing or cleaning them up\n",
+			highlighted, manyTinyFiles)
+	}
+}
+
+// sizeStat is a named size, used to sort statistics maps for display
+type sizeStat struct {
+	name string
+	size int64
+}
+
+// sortedBySize converts a statistics map to a slice sorted by size (descending)
+func sortedBySize(stats map[string]int64) []sizeStat {
+	list := make([]sizeStat, 0, len(stats))
+	for name, size := range stats {
+		list = append(list, sizeStat{name, size})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].size != list[j].size {
+			return list[i].size > list[j].size
+		}
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+// percentOf returns part as a percentage of total, or 0 for an empty total
+func percentOf(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// usageEntry is a named size in a disk usage report
+type usageEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// diskUsageReport is the machine-readable form of a disk usage analysis
+type diskUsageReport struct {
+	Root        string       `json:"root"`
+	TotalSize   int64        `json:"total_size"`
+	Types       []usageEntry `json:"types"`
+	Directories []usageEntry `json:"directories"`
+}
+
+// report converts the statistics to a report listing at most limit directories
+func (u *diskUsage) report(limit int) diskUsageReport {
+	report := diskUsageReport{
+		Root:      u.root,
+		TotalSize: u.totalSize,
+	}
+	for _, stat := range sortedBySize(u.typeStats) {
+		report.Types = append(report.Types, usageEntry{stat.name, stat.size})
+	}
+	for _, stat := range sortedBySize(u.dirStats) {
+		if len(report.Directories) >= limit {
+			break
+		}
+		report.Directories = append(report.Directories, usageEntry{stat.name, stat.size})
+	}
+	return report
+}
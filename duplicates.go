@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scanProgress receives progress updates from long-running scans. total is
+// 0 while the amount of work isn't known yet.
+type scanProgress func(phase string, done, total int)
+
+// errReflinkUnsupported is returned when the filesystem can't share data
+// between files
+var errReflinkUnsupported = errors.New("reflinks are not supported on this filesystem")
+
+// duplicateGroup is a set of files with identical content. Files are ordered
+// with the suggested original first, which is the copy actions keep.
+type duplicateGroup struct {
+	Hash     string   `json:"hash"`
+	Size     int64    `json:"size"`
+	Files    []string `json:"files"`
+	Original string   `json:"original"`
+	Reason   string   `json:"reason"`
+
+	// Shared lists files that already share their storage with an earlier
+	// file of the group, and so waste no space
+	Shared []sharedCopy `json:"shared,omitempty"`
+}
+
+// sharedCopy is a file whose data is stored only once together with With
+type sharedCopy struct {
+	File string `json:"file"`
+	With string `json:"with"`
+
+	// Kind is "hard link" or "reflink"
+	Kind string `json:"kind"`
+}
+
+// findShared records the files that are hard links or reflinked clones of
+// an earlier file of the group. Run it after chooseOriginal, so files are
+// attributed to the original where possible.
+func (g *duplicateGroup) findShared() {
+	g.Shared = nil
+	var inodes []os.FileInfo
+	var inodeFiles []string
+	byExtents := make(map[string]string)
+
+nextFile:
+	for _, file := range g.Files {
+		info, err := os.Lstat(file)
+		if err != nil {
+			continue
+		}
+
+		for i, other := range inodes {
+			if os.SameFile(info, other) {
+				g.Shared = append(g.Shared, sharedCopy{File: file, With: inodeFiles[i], Kind: "hard link"})
+				continue nextFile
+			}
+		}
+		inodes = append(inodes, info)
+		inodeFiles = append(inodeFiles, file)
+
+		if extents, ok := sharedExtents(file); ok {
+			if first, ok := byExtents[extents]; ok {
+				g.Shared = append(g.Shared, sharedCopy{File: file, With: first, Kind: "reflink"})
+				continue
+			}
+			byExtents[extents] = file
+		}
+	}
+}
+
+// sharedWith returns the file a copy shares its storage with, or ""
+func (g duplicateGroup) sharedWith(file string) (sharedCopy, bool) {
+	for _, shared := range g.Shared {
+		if shared.File == file {
+			return shared, true
+		}
+	}
+	return sharedCopy{}, false
+}
+
+// chooseOriginal orders the files of a group and picks the original: a file
+// inside a master directory, otherwise the earliest modified one. Remaining
+// ties are broken by path, so the choice is the same on every run.
+func (g *duplicateGroup) chooseOriginal(modTimes map[string]time.Time, masters []string) {
+	inMaster := func(file string) bool {
+		return masterDirFor(file, masters) != ""
+	}
+
+	sort.Slice(g.Files, func(i, j int) bool {
+		a, b := g.Files[i], g.Files[j]
+		if inMaster(a) != inMaster(b) {
+			return inMaster(a)
+		}
+		if !modTimes[a].Equal(modTimes[b]) {
+			return modTimes[a].Before(modTimes[b])
+		}
+		return a < b
+	})
+
+	g.Original = g.Files[0]
+
+	// Hard links of the original always have its modification time, so
+	// they don't make a tie
+	tie := false
+	for _, file := range g.Files[1:] {
+		if modTimes[file].Equal(modTimes[g.Original]) && !sameInode(file, g.Original) {
+			tie = true
+			break
+		}
+	}
+
+	switch {
+	case inMaster(g.Original):
+		g.Reason = "in master directory " + masterDirFor(g.Original, masters)
+	case tie:
+		g.Reason = "same modification time, first by path"
+	default:
+		g.Reason = "earliest modified"
+	}
+}
+
+// sameInode reports whether two paths are hard links of the same file
+func sameInode(a, b string) bool {
+	infoA, errA := os.Lstat(a)
+	infoB, errB := os.Lstat(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// Deletable returns the copies that duplicate actions may remove: all files
+// except the original, anything inside a master directory and copies that
+// already share the original's storage, as removing them frees nothing.
+// Duplicates among master directories are only ever reported.
+func (g duplicateGroup) Deletable(masters []string) []string {
+	var files []string
+	for _, file := range g.Files {
+		if file == g.Original || masterDirFor(file, masters) != "" {
+			continue
+		}
+		if shared, ok := g.sharedWith(file); ok && shared.With == g.Original {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// masterDirFor returns the master directory containing path, or "" if none
+// does
+func masterDirFor(path string, masters []string) string {
+	for _, master := range masters {
+		if path == master || isAncestorPath(master, path) {
+			return master
+		}
+	}
+	return ""
+}
+
+// Wasted returns the space used by all copies but one, not counting copies
+// that share their storage with another
+func (g duplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Files)-len(g.Shared)-1)
+}
+
+// scanDuplicates finds groups of identical files below absPath. Progress is
+// recorded in checkpoint, which is saved periodically; when ctx is cancelled
+// the scan stops and returns the context's error.
+func scanDuplicates(ctx context.Context, absPath string, checkpoint *duplicateCheckpoint, progress scanProgress) (groups []duplicateGroup, err error) {
+	if progress == nil {
+		progress = func(string, int, int) {}
+	}
+
+	ctx, span := tracer.Start(ctx, "find-duplicates", trace.WithAttributes(
+		attribute.String("dirmon.root", absPath),
+		attribute.Bool("dirmon.resumed", len(checkpoint.Hashes) > 0 || checkpoint.LastWalked != ""),
+	))
+	defer func() { endSpan(span, err) }()
+	walkedAttrs := scanAttrs("find-duplicates", "walk")
+	hashedAttrs := scanAttrs("find-duplicates", "hash")
+
+	// First pass: get file sizes and organize by size
+	filesBySize := checkpoint.FilesBySize
+	ignore := newIgnoreMatcher(absPath)
+
+	if !checkpoint.WalkDone {
+		lastWalked := checkpoint.LastWalked
+		walked := 0
+		_, walkSpan := tracer.Start(ctx, "walk")
+
+		err := filepath.Walk(absPath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// Skip everything a resumed scan has already recorded
+			if lastWalked != "" && filePath != absPath && !isAncestorPath(filePath, lastWalked) &&
+				walkedBefore(filePath, lastWalked) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if filePath != absPath && ignore.Match(filePath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !info.IsDir() {
+				filesBySize[info.Size()] = append(filesBySize[info.Size()], filePath)
+				checkpoint.LastWalked = filePath
+				walked++
+				progress("walking", walked, 0)
+				scanFilesCounter.Add(ctx, 1, walkedAttrs)
+			}
+
+			if err := checkpoint.saveIfDue(); err != nil {
+				fmt.Printf("Error saving checkpoint: %v\n", err)
+			}
+			return nil
+		})
+		walkSpan.SetAttributes(attribute.Int("dirmon.files", walked))
+		endSpan(walkSpan, err)
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint.WalkDone = true
+	}
+
+	// Files with the same size are potential duplicates
+	total := 0
+	for size, files := range filesBySize {
+		if len(files) > 1 && size > 0 {
+			total += len(files)
+		}
+	}
+
+	// Second pass: compute MD5 hashes for potential duplicates (files with same size)
+	byHash := make(map[string]*duplicateGroup)
+	modTimes := make(map[string]time.Time)
+	hashed := 0
+	var hashedBytes int64
+
+	_, hashSpan := tracer.Start(ctx, "hash", trace.WithAttributes(attribute.Int("dirmon.candidates", total)))
+	defer hashSpan.End()
+
+	for size, files := range filesBySize {
+		if len(files) < 2 || size == 0 {
+			continue
+		}
+
+		for _, file := range files {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			hashed++
+			progress("hashing", hashed, total)
+
+			info, err := os.Stat(file)
+			if err != nil {
+				fmt.Printf("Error calculating hash for %s: %v\n", file, err)
+				continue
+			}
+
+			// Reuse hashes from the checkpoint if the file hasn't changed
+			entry, ok := checkpoint.Hashes[file]
+			if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+				hash, err := calculateMD5(file)
+				if err != nil {
+					fmt.Printf("Error calculating hash for %s: %v\n", file, err)
+					continue
+				}
+				hashedBytes += info.Size()
+				scanFilesCounter.Add(ctx, 1, hashedAttrs)
+				hashedBytesCounter.Add(ctx, info.Size())
+
+				entry = hashEntry{Size: info.Size(), ModTime: info.ModTime(), Hash: hash}
+				checkpoint.Hashes[file] = entry
+				if err := checkpoint.saveIfDue(); err != nil {
+					fmt.Printf("Error saving checkpoint: %v\n", err)
+				}
+			}
+
+			group, ok := byHash[entry.Hash]
+			if !ok {
+				group = &duplicateGroup{Hash: entry.Hash, Size: entry.Size}
+				byHash[entry.Hash] = group
+			}
+			group.Files = append(group.Files, file)
+			modTimes[file] = entry.ModTime
+		}
+	}
+
+	hashSpan.SetAttributes(
+		attribute.Int("dirmon.files", hashed),
+		attribute.Int64("dirmon.hashed_bytes", hashedBytes),
+	)
+
+	for _, group := range byHash {
+		if len(group.Files) > 1 {
+			group.chooseOriginal(modTimes, appConfig.MasterDirs)
+			group.findShared()
+			groups = append(groups, *group)
+		}
+	}
+
+	// Largest savings first
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Wasted() != groups[j].Wasted() {
+			return groups[i].Wasted() > groups[j].Wasted()
+		}
+		return groups[i].Hash < groups[j].Hash
+	})
+
+	span.SetAttributes(attribute.Int("dirmon.duplicate_groups", len(groups)))
+	return groups, nil
+}
+
+// duplicateOptions holds the optional behaviour of the find-duplicates command
+type duplicateOptions struct {
+	Resume       bool
+	DeleteCopies bool
+	Yes          bool
+
+	// Reflink makes copies share the original's storage instead of
+	// deleting them
+	Reflink bool
+}
+
+// findDuplicateFiles identifies potential duplicate files in a directory.
+// Progress is checkpointed periodically so that an interrupted scan can be
+// continued with resume instead of starting over.
+func findDuplicateFiles(path string, opts duplicateOptions) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var checkpoint *duplicateCheckpoint
+	if opts.Resume {
+		checkpoint, err = loadDuplicateCheckpoint(absPath)
+		if err != nil {
+			fmt.Printf("No usable checkpoint for %s, starting a new scan\n", absPath)
+		} else {
+			fmt.Printf("Resuming scan from checkpoint saved at %s (%d files hashed)\n",
+				checkpoint.Updated.Format("2006-01-02 15:04:05"), len(checkpoint.Hashes))
+		}
+	} else if existing, err := loadDuplicateCheckpoint(absPath); err == nil {
+		fmt.Printf("Found checkpoint from %s, use --resume to continue it. Starting a new scan.\n",
+			existing.Updated.Format("2006-01-02 15:04:05"))
+	}
+	if checkpoint == nil {
+		checkpoint = newDuplicateCheckpoint(absPath)
+	}
+
+	groups, err := scanDuplicates(ctx, absPath, checkpoint, nil)
+	if ctx.Err() != nil {
+		// Save the current progress before giving up
+		if err := checkpoint.save(); err != nil {
+			return fmt.Errorf("scan interrupted and checkpoint could not be saved: %v", err)
+		}
+		return fmt.Errorf("scan interrupted, run again with --resume to continue")
+	}
+	if err != nil {
+		return err
+	}
+
+	checkpoint.remove()
+
+	printDuplicateGroups(groups)
+	if opts.DeleteCopies && len(groups) > 0 {
+		return deleteDuplicateCopies(absPath, groups, opts.Yes)
+	}
+	if opts.Reflink && len(groups) > 0 {
+		return reflinkDuplicateCopies(absPath, groups, opts.Yes)
+	}
+	return nil
+}
+
+// deleteDuplicateCopies removes every file of the groups except the original
+// and copies inside master directories, after confirmation unless assumeYes
+// is set. Each copy and its original are
+// hashed again first, so files changed since the scan are never removed.
+// The space reclaimed is recorded as savings of the scanned directory root.
+func deleteDuplicateCopies(root string, groups []duplicateGroup, assumeYes bool) error {
+	copies, total := countDeletable(groups)
+	if copies == 0 {
+		fmt.Println("\nNothing to delete, all copies are inside master directories or already share the original's storage")
+		return nil
+	}
+
+	if !assumeYes {
+		fmt.Printf("\nDelete %d copies (%s), keeping the original of each group? (y/N): ", copies, formatSize(total))
+		var response string
+		fmt.Scanln(&response)
+
+		if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+			fmt.Println("Operation cancelled")
+			return nil
+		}
+	}
+
+	var reclaimed int64
+	deleted, skipped := 0, 0
+	savings := newSavingsRecorder("dedupe")
+	for _, group := range groups {
+		deletable := group.Deletable(appConfig.MasterDirs)
+		if len(deletable) == 0 {
+			continue
+		}
+
+		if hash, err := calculateMD5(group.Original); err != nil || hash != group.Hash {
+			fmt.Printf("Skipping group %s: original %s changed since the scan\n", group.Hash[:8], group.Original)
+			skipped += len(deletable)
+			continue
+		}
+
+		for _, file := range deletable {
+			if hash, err := calculateMD5(file); err != nil || hash != group.Hash {
+				fmt.Printf("Skipping %s: changed since the scan\n", file)
+				skipped++
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				fmt.Printf("Error deleting %s: %v\n", file, err)
+				skipped++
+				continue
+			}
+			deleted++
+
+			// Shared storage is only freed once all its files are gone,
+			// which is counted with the first of them
+			freed := int64(0)
+			if _, ok := group.sharedWith(file); !ok {
+				freed = group.Size
+			}
+			reclaimed += freed
+			savings.Add(root, "delete-copies", freed)
+		}
+	}
+	savings.Save()
+
+	fmt.Printf("Deleted %d copies, reclaimed %s", deleted, formatSize(reclaimed))
+	if skipped > 0 {
+		fmt.Printf(" (%d skipped)", skipped)
+	}
+	fmt.Println()
+	return nil
+}
+
+// countDeletable returns the number of copies duplicate actions may change
+// and the space that frees
+func countDeletable(groups []duplicateGroup) (int, int64) {
+	var copies int
+	var total int64
+	for _, group := range groups {
+		for _, file := range group.Deletable(appConfig.MasterDirs) {
+			copies++
+			if _, ok := group.sharedWith(file); !ok {
+				total += group.Size
+			}
+		}
+	}
+	return copies, total
+}
+
+// reflinkDuplicateCopies makes every copy that duplicate actions may change
+// share the storage of its group's original, keeping all files in place.
+// The kernel verifies that the contents are identical before sharing them.
+func reflinkDuplicateCopies(root string, groups []duplicateGroup, assumeYes bool) error {
+	copies, total := countDeletable(groups)
+	if copies == 0 {
+		fmt.Println("\nNothing to reflink, all copies are inside master directories or already share the original's storage")
+		return nil
+	}
+
+	if !assumeYes {
+		fmt.Printf("\nReflink %d copies (%s) to the original of each group? (y/N): ", copies, formatSize(total))
+		var response string
+		fmt.Scanln(&response)
+
+		if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+			fmt.Println("Operation cancelled")
+			return nil
+		}
+	}
+
+	var reclaimed int64
+	linked, skipped := 0, 0
+	savings := newSavingsRecorder("reflink")
+	defer savings.Save()
+	for _, group := range groups {
+		for _, file := range group.Deletable(appConfig.MasterDirs) {
+			err := reflinkFile(group.Original, file)
+			if errors.Is(err, errReflinkUnsupported) {
+				return fmt.Errorf("%v, nothing more was changed (%d copies reflinked)", err, linked)
+			}
+			if err != nil {
+				fmt.Printf("Skipping %s: %v\n", file, err)
+				skipped++
+				continue
+			}
+
+			linked++
+			freed := int64(0)
+			if _, ok := group.sharedWith(file); !ok {
+				freed = group.Size
+			}
+			reclaimed += freed
+			savings.Add(root, "reflink-copies", freed)
+		}
+	}
+
+	fmt.Printf("Reflinked %d copies, reclaimed %s", linked, formatSize(reclaimed))
+	if skipped > 0 {
+		fmt.Printf(" (%d skipped)", skipped)
+	}
+	fmt.Println()
+	return nil
+}
+
+// printDuplicateGroups prints duplicate groups and the space they waste
+func printDuplicateGroups(groups []duplicateGroup) {
+	var totalWasted, totalShared int64
+
+	fmt.Println("Duplicate files:")
+	fmt.Println(strings.Repeat("-", 80))
+
+	for i, group := range groups {
+		totalWasted += group.Wasted()
+		totalShared += group.Size * int64(len(group.Shared))
+
+		fmt.Printf("\nDuplicate Group %d (%s, wasted: %s):\n",
+			i+1, group.Hash[:8], formatSize(group.Wasted()))
+		if len(appConfig.MasterDirs) > 0 && len(group.Deletable(appConfig.MasterDirs)) == 0 {
+			fmt.Println("(report only, all copies are inside master directories)")
+		}
+
+		for j, file := range group.Files {
+			shared, isShared := group.sharedWith(file)
+			switch {
+			case isShared:
+				fmt.Printf("%d. %s [already deduplicated: %s of %s]\n", j+1, file, shared.Kind, shared.With)
+			case file == group.Original:
+				fmt.Printf("%d. %s [original: %s]\n", j+1, file, group.Reason)
+			case masterDirFor(file, appConfig.MasterDirs) != "":
+				fmt.Printf("%d. %s [master, kept]\n", j+1, file)
+			default:
+				fmt.Printf("%d. %s\n", j+1, file)
+			}
+		}
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No duplicate files found.")
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("Found %d groups of duplicate files\n", len(groups))
+	fmt.Printf("Potential space savings: %s\n", formatSize(totalWasted))
+	if totalShared > 0 {
+		fmt.Printf("Already deduplicated: %s in hard links and reflinks\n", formatSize(totalShared))
+	}
+}
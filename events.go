@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTimeFormat stores event times in a form SQLite's date and time
+// functions understand, e.g. WHERE time > datetime('now', '-1 day')
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+// Event is a filesystem change observed by the monitor commands
+type Event struct {
+	Time   time.Time `json:"time"`
+	Root   string    `json:"root"`
+	Path   string    `json:"path"`
+	Op     string    `json:"op"`
+	Detail string    `json:"detail,omitempty"`
+
+	// Size is the size of a regular file after the change, or for deleted
+	// and renamed files the last size seen while monitoring
+	Size int64 `json:"size,omitempty"`
+
+	// User and Process caused the change, when monitoring with --attribute
+	User    string `json:"user,omitempty"`
+	Process string `json:"process,omitempty"`
+}
+
+// eventSink persists events
+type eventSink interface {
+	Name() string
+	Write(event Event) error
+	Prune() (int64, error)
+	Close() error
+}
+
+// jsonlSink appends events to a file as JSON lines
+type jsonlSink struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// openJSONLSink opens (or creates) a JSON lines event log
+func openJSONLSink(path string) (*jsonlSink, error) {
+	sink := &jsonlSink{path: path}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *jsonlSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.encoder = json.NewEncoder(file)
+	return nil
+}
+
+func (s *jsonlSink) Name() string {
+	return s.path
+}
+
+func (s *jsonlSink) Write(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(event)
+}
+
+// Prune rewrites the log without expired events; the file is reopened
+// afterwards since pruning replaces it
+func (s *jsonlSink) Prune() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.file.Close()
+	removed, err := pruneEventLog(s.path)
+	if openErr := s.open(); openErr != nil {
+		return removed, openErr
+	}
+	return removed, err
+}
+
+func (s *jsonlSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// sqliteSchema creates the events table and the indices used by typical
+// queries: by time range, by file, and by directory or operation over time
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS events (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	time    TEXT NOT NULL,
+	root    TEXT NOT NULL,
+	path    TEXT NOT NULL,
+	dir     TEXT NOT NULL,
+	name    TEXT NOT NULL,
+	ext     TEXT NOT NULL,
+	op      TEXT NOT NULL,
+	detail  TEXT NOT NULL DEFAULT '',
+	size    INTEGER NOT NULL DEFAULT 0,
+	user    TEXT NOT NULL DEFAULT '',
+	process TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_events_time ON events(time);
+CREATE INDEX IF NOT EXISTS idx_events_path ON events(path);
+CREATE INDEX IF NOT EXISTS idx_events_root_time ON events(root, time);
+CREATE INDEX IF NOT EXISTS idx_events_op_time ON events(op, time);
+`
+
+// sqliteSink stores events in an SQLite database
+type sqliteSink struct {
+	path string
+	db   *sql.DB
+	stmt *sql.Stmt
+}
+
+// sqliteURI returns the file: URI opening the database at path with the
+// given query parameters. The path is escaped, so that names containing ?, #
+// or % open the right file.
+func sqliteURI(path, params string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	uriPath := filepath.ToSlash(absPath)
+	if !strings.HasPrefix(uriPath, "/") {
+		uriPath = "/" + uriPath // Windows drive letters
+	}
+	uri := url.URL{Scheme: "file", Path: uriPath, RawQuery: params}
+	return uri.String(), nil
+}
+
+// openEventDB opens an SQLite event database, creating the schema if needed
+func openEventDB(path string) (*sql.DB, error) {
+	uri, err := sqliteURI(path, "")
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec("PRAGMA journal_mode=WAL; PRAGMA busy_timeout=5000;" + sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrateEventDB(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// eventColumns are the columns added to the events table after its first
+// release, with their definitions
+var eventColumns = [][2]string{
+	{"size", "INTEGER NOT NULL DEFAULT 0"},
+	{"user", "TEXT NOT NULL DEFAULT ''"},
+	{"process", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrateEventDB adds columns missing from databases created by older
+// versions
+func migrateEventDB(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('events')")
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range eventColumns {
+		if existing[column[0]] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE events ADD COLUMN " + column[0] + " " + column[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSQLiteSink opens an SQLite database as an event sink
+func openSQLiteSink(path string) (*sqliteSink, error) {
+	db, err := openEventDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := db.Prepare(`INSERT INTO events (time, root, path, dir, name, ext, op, detail, size, user, process)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteSink{path: path, db: db, stmt: stmt}, nil
+}
+
+func (s *sqliteSink) Name() string {
+	return s.path
+}
+
+func (s *sqliteSink) Write(event Event) error {
+	_, err := s.stmt.Exec(
+		event.Time.UTC().Format(sqliteTimeFormat),
+		event.Root,
+		event.Path,
+		filepath.Dir(event.Path),
+		filepath.Base(event.Path),
+		strings.ToLower(filepath.Ext(event.Path)),
+		event.Op,
+		event.Detail,
+		event.Size,
+		event.User,
+		event.Process,
+	)
+	return err
+}
+
+func (s *sqliteSink) Prune() (int64, error) {
+	return pruneEventDB(s.db)
+}
+
+func (s *sqliteSink) Close() error {
+	s.stmt.Close()
+	return s.db.Close()
+}
+
+// eventRecorder writes events to every configured sink
+type eventRecorder struct {
+	sinks []eventSink
+}
+
+// newEventRecorder opens the sinks requested by the monitor options
+func newEventRecorder(opts monitorOptions) (*eventRecorder, error) {
+	recorder := &eventRecorder{}
+
+	if opts.EventLog != "" {
+		sink, err := openJSONLSink(opts.EventLog)
+		if err != nil {
+			return nil, err
+		}
+		recorder.sinks = append(recorder.sinks, sink)
+	}
+
+	if opts.EventDB != "" {
+		sink, err := openSQLiteSink(opts.EventDB)
+		if err != nil {
+			recorder.Close()
+			return nil, err
+		}
+		recorder.sinks = append(recorder.sinks, sink)
+	}
+
+	return recorder, nil
+}
+
+// Record stores an event, reporting (but not failing on) sink errors
+func (r *eventRecorder) Record(event Event) {
+	for _, sink := range r.sinks {
+		if err := sink.Write(event); err != nil {
+			fmt.Printf("[ERROR] recording event: %v\n", err)
+		}
+	}
+
+	ctx := context.Background()
+	eventsCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("op", event.Op)))
+	eventRecordDuration.Record(ctx, secondsSince(event.Time))
+}
+
+// IsOwnFile reports whether path belongs to one of the sinks (including
+// SQLite's journal files and temporary files written while pruning), so that
+// recording events never triggers further events about itself
+func (r *eventRecorder) IsOwnFile(path string) bool {
+	for _, sink := range r.sinks {
+		own, err := filepath.Abs(sink.Name())
+		if err != nil {
+			continue
+		}
+		if path == own || strings.HasPrefix(path, own+"-") || path == own+".tmp" {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes all sinks
+func (r *eventRecorder) Close() {
+	for _, sink := range r.sinks {
+		sink.Close()
+	}
+}
+
+// defaultEventDBPath returns the event database used when none is given
+func defaultEventDBPath() string {
+	return state.EventDBPath()
+}
+
+// queryResult holds the rows of an SQL query as strings
+type queryResult struct {
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+// openEventDBReadOnly opens an existing event database without write access
+func openEventDBReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("event database %s not found, record events with --event-db first", path)
+	}
+
+	uri, err := sqliteURI(path, "mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite", uri)
+}
+
+// checkEventQuery makes sure a query only reads the recorded events: it
+// must be a single SELECT, so it can't attach other databases or change
+// settings, and it may only open the events table, its indices and the
+// schema, so state kept in the same database (the SQLite state store) stays
+// out of reach
+func checkEventQuery(db *sql.DB, query string) error {
+	if err := checkSingleSelect(query); err != nil {
+		return err
+	}
+
+	allowed := map[int64]bool{1: true} // sqlite_master
+	rows, err := db.Query("SELECT rootpage FROM sqlite_master WHERE tbl_name = 'events'")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var page int64
+		if err := rows.Scan(&page); err != nil {
+			rows.Close()
+			return err
+		}
+		allowed[page] = true
+	}
+	rows.Close()
+
+	// The query plan names every table and index the query opens by its
+	// root page, and the database it is in (0 for the main one)
+	rows, err = db.Query("EXPLAIN " + query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addr, p1, p2, p3, p5 int64
+		var opcode string
+		var p4, comment sql.NullString
+		if err := rows.Scan(&addr, &opcode, &p1, &p2, &p3, &p4, &p5, &comment); err != nil {
+			return err
+		}
+		switch opcode {
+		case "OpenRead", "OpenWrite", "ReopenIdx":
+			if p3 != 0 || !allowed[p2] {
+				return fmt.Errorf("queries may only read the events table")
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// checkSingleSelect reports an error unless query is exactly one SELECT
+// statement (optionally starting with WITH), skipping over comments,
+// string literals and quoted names
+func checkSingleSelect(query string) error {
+	first := ""
+	started, ended := false, false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue
+		case c == ';':
+			ended = true
+			i++
+			continue
+		}
+
+		if ended {
+			return fmt.Errorf("only a single statement is allowed")
+		}
+		if !started {
+			started = true
+			end := i
+			for end < len(query) && isWordByte(query[end]) {
+				end++
+			}
+			first = strings.ToUpper(query[i:end])
+		}
+
+		switch c {
+		case '\'', '"', '`', '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			i++
+			for i < len(query) {
+				if query[i] == closing {
+					// Quotes are escaped by doubling them
+					if closing != ']' && i+1 < len(query) && query[i+1] == closing {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+		}
+		i++
+	}
+
+	if first != "SELECT" && first != "WITH" {
+		return fmt.Errorf("only SELECT queries are allowed")
+	}
+	return nil
+}
+
+// isWordByte reports whether c can be part of an SQL keyword
+func isWordByte(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// runEventQuery runs an SQL query and collects the result, with NULL values
+// shown as "NULL"
+func runEventQuery(db *sql.DB, query string) (*queryResult, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	result := &queryResult{Columns: columns, Rows: [][]string{}}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		fields := make([]string, len(values))
+		for i, value := range values {
+			fields[i] = "NULL"
+			if value.Valid {
+				fields[i] = value.String
+			}
+		}
+		result.Rows = append(result.Rows, fields)
+	}
+	return result, rows.Err()
+}
+
+// printQueryResult prints a query result as a table
+func printQueryResult(result *queryResult) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.ToUpper(strings.Join(result.Columns, "\t")))
+	for _, row := range result.Rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+	fmt.Printf("(%d rows)\n", len(result.Rows))
+}
+
+// queryEvents runs an SQL query against an event database and prints the
+// result as a table
+func queryEvents(dbPath, query string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("event database %s not found, record events with --event-db first", dbPath)
+	}
+
+	db, err := openEventDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := runEventQuery(db, query)
+	if err != nil {
+		return err
+	}
+
+	printQueryResult(result)
+	return nil
+}
+
+// eventFilter restricts the events returned by listEvents; zero values
+// match everything
+type eventFilter struct {
+	Since time.Time
+	Root  string
+	Op    string
+	Limit int
+}
+
+// listEvents returns events matching the filter, newest first
+func listEvents(db *sql.DB, filter eventFilter) ([]Event, error) {
+	query := "SELECT time, root, path, op, detail, size, user, process FROM events WHERE 1=1"
+	var args []any
+	if !filter.Since.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, filter.Since.UTC().Format(sqliteTimeFormat))
+	}
+	if filter.Root != "" {
+		query += " AND root = ?"
+		args = append(args, filter.Root)
+	}
+	if filter.Op != "" {
+		query += " AND op = ?"
+		args = append(args, strings.ToUpper(filter.Op))
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var eventTime string
+		if err := rows.Scan(&eventTime, &event.Root, &event.Path, &event.Op, &event.Detail,
+			&event.Size, &event.User, &event.Process); err != nil {
+			return nil, err
+		}
+		event.Time, _ = time.ParseInLocation(sqliteTimeFormat, eventTime, time.UTC)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
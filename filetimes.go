@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileTimes holds the timestamps the current platform can report for a file
+type fileTimes struct {
+	Modified time.Time
+	Accessed time.Time // Zero when the platform can't report it
+	Born     time.Time // Zero when the platform or filesystem doesn't record it
+}
+
+// getFileTimes returns the modification, access and birth time of a file.
+// Times the platform can't report are left zero rather than guessed, so
+// atime-based decisions aren't made on the modification time.
+func getFileTimes(path string, info os.FileInfo) fileTimes {
+	times := fileTimes{
+		Modified: info.ModTime(),
+	}
+	platformFileTimes(path, info, &times)
+	return times
+}
+
+// formatOptionalTime formats t for table output, or "-" if it is unknown
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,22 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// platformFileTimes reads the access and birth time from the BSD stat structure
+func platformFileTimes(path string, info os.FileInfo, times *fileTimes) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	times.Accessed = time.Unix(st.Atimespec.Unix())
+	if st.Birthtimespec.Sec > 0 {
+		times.Born = time.Unix(st.Birthtimespec.Unix())
+	}
+}
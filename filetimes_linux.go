@@ -0,0 +1,33 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// platformFileTimes uses statx so the birth time is available on filesystems
+// that record it (ext4, btrfs, xfs, ...)
+func platformFileTimes(path string, info os.FileInfo, times *fileTimes) {
+	var stx unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW,
+		unix.STATX_ATIME|unix.STATX_BTIME, &stx)
+	if err != nil {
+		// Kernels older than 4.11 don't support statx
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			times.Accessed = time.Unix(st.Atim.Unix())
+		}
+		return
+	}
+
+	if stx.Mask&unix.STATX_ATIME != 0 {
+		times.Accessed = time.Unix(stx.Atime.Sec, int64(stx.Atime.Nsec))
+	}
+	if stx.Mask&unix.STATX_BTIME != 0 {
+		times.Born = time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+	}
+}
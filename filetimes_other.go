@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package main
+
+import "os"
+
+// platformFileTimes is a no-op on platforms without access/birth time support
+func platformFileTimes(path string, info os.FileInfo, times *fileTimes) {}
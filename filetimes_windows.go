@@ -0,0 +1,20 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// platformFileTimes reads the access and creation time from the file attributes
+func platformFileTimes(path string, info os.FileInfo, times *fileTimes) {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return
+	}
+
+	times.Accessed = time.Unix(0, attrs.LastAccessTime.Nanoseconds())
+	times.Born = time.Unix(0, attrs.CreationTime.Nanoseconds())
+}
@@ -0,0 +1,753 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+const (
+	// defaultHookTimeout bounds how long a hook command may run
+	defaultHookTimeout = 30 * time.Second
+
+	// defaultHookWorkers is how many hook commands run at once
+	defaultHookWorkers = 4
+
+	// hookQueueSize is how many hook runs may wait for a worker before
+	// further ones are dropped, so a backlog never stalls event handling
+	hookQueueSize = 256
+
+	// hookOutputLimit caps the stdout and stderr kept per hook run
+	hookOutputLimit = 16 * 1024
+
+	// hookKillDelay is how long to wait for a killed hook's output pipes to
+	// close, in case it left children behind holding them open
+	hookKillDelay = 2 * time.Second
+)
+
+// HookRule runs an action for every event matching it: a command, or moving
+// or copying the file. Command arguments, environment values and the
+// destination are templates over hookTemplateData, e.g.
+// "/archive/{{.ModTime.Year}}/{{.Base}}".
+type HookRule struct {
+	Name string `json:"name"`
+
+	// Match is a .dirmonignore style pattern for the event's path relative to
+	// its monitored directory; empty matches every path
+	Match string `json:"match,omitempty"`
+
+	// Ops restricts the rule to event types such as CREATED or MODIFIED;
+	// empty matches all of them
+	Ops []string `json:"ops,omitempty"`
+
+	// Command is the program and its arguments; it is not run through a shell
+	Command []string `json:"command,omitempty"`
+
+	// Move is the destination the file is moved to, relative to the
+	// monitored directory unless absolute. Missing directories are created;
+	// existing files are never overwritten.
+	Move string `json:"move,omitempty"`
+
+	// Copy is a destination like Move, but the file is copied and kept
+	Copy string `json:"copy,omitempty"`
+
+	// BandwidthLimit caps how fast files are copied, e.g. "10MB" per second.
+	// Moves within a filesystem are renames and not limited.
+	BandwidthLimit string `json:"bandwidth_limit,omitempty"`
+
+	// Env adds variables to the command's environment
+	Env map[string]string `json:"env,omitempty"`
+
+	TimeoutSeconds    int `json:"timeout_seconds,omitempty"`
+	Retries           int `json:"retries,omitempty"`
+	RetryDelaySeconds int `json:"retry_delay_seconds,omitempty"`
+
+	// MaxConcurrent limits how many runs of this rule may overlap; 0 means
+	// only the global worker limit applies
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+
+	// shell marks --exec rules, whose command is a shell script: template
+	// values are quoted for the shell before they are inserted
+	shell bool
+}
+
+// timeout returns the time a single run of the rule may take
+func (r HookRule) timeout() time.Duration {
+	if r.TimeoutSeconds <= 0 {
+		return defaultHookTimeout
+	}
+	return time.Duration(r.TimeoutSeconds) * time.Second
+}
+
+// retryDelay returns the wait before the given retry (1 for the first); the
+// delay doubles with every attempt
+func (r HookRule) retryDelay(retry int) time.Duration {
+	delay := time.Second
+	if r.RetryDelaySeconds > 0 {
+		delay = time.Duration(r.RetryDelaySeconds) * time.Second
+	}
+	return delay << (retry - 1)
+}
+
+// shellHooks returns ad-hoc rules running --exec command lines through the
+// system shell for every event. With several commands the rules are named
+// "exec 1", "exec 2", ... so their output and matches can be told apart.
+func shellHooks(commands []string) []HookRule {
+	rules := make([]HookRule, 0, len(commands))
+	for i, command := range commands {
+		shell := []string{"sh", "-c", command}
+		if runtime.GOOS == "windows" {
+			shell = []string{"cmd", "/C", command}
+		}
+
+		name := "exec"
+		if len(commands) > 1 {
+			name = fmt.Sprintf("exec %d", i+1)
+		}
+		rules = append(rules, HookRule{Name: name, Command: shell, shell: true})
+	}
+	return rules
+}
+
+// hookTemplateData is what hook templates can refer to
+type hookTemplateData struct {
+	Path      string    // absolute path of the file
+	Dir       string    // directory containing the file
+	Base      string    // file name, e.g. "report.pdf"
+	Name      string    // file name without extension, e.g. "report"
+	Ext       string    // extension including the dot, e.g. ".pdf"
+	Rel       string    // path relative to the monitored directory
+	Root      string    // monitored directory
+	Op        string    // event type, e.g. CREATED
+	Time      time.Time // when the event happened
+	SizeBytes int64     // size of the file, 0 if it no longer exists
+	ModTime   time.Time // modification time, the event time if the file no longer exists
+}
+
+// newHookTemplateData describes an event and the file's current metadata
+func newHookTemplateData(event Event) hookTemplateData {
+	base := filepath.Base(event.Path)
+	ext := filepath.Ext(base)
+	data := hookTemplateData{
+		Path:    event.Path,
+		Dir:     filepath.Dir(event.Path),
+		Base:    base,
+		Name:    strings.TrimSuffix(base, ext),
+		Ext:     ext,
+		Rel:     event.Path,
+		Root:    event.Root,
+		Op:      event.Op,
+		Time:    event.Time,
+		ModTime: event.Time,
+	}
+	if rel, err := filepath.Rel(event.Root, event.Path); err == nil {
+		data.Rel = rel
+	}
+	if info, err := os.Lstat(event.Path); err == nil {
+		data.SizeBytes = info.Size()
+		data.ModTime = info.ModTime()
+	}
+	return data
+}
+
+// quoteActions makes every action in a template pipe its output through
+// shellquote, so that whatever a --exec command line renders, be it a file
+// name like "x;rm -rf ~" or a time with spaces, reaches the shell as one word
+func quoteActions(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			quoteActions(child)
+		}
+	case *parse.ActionNode:
+		// Assignments such as {{$x := .Path}} print nothing
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		quote := parse.NewIdentifier("shellquote").SetTree(nil).SetPos(n.Pos)
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: n.Pos, Args: []parse.Node{quote}})
+	case *parse.IfNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	case *parse.RangeNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	case *parse.WithNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	}
+}
+
+// shellQuote quotes a string as one word for sh, or for cmd on Windows
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		// Windows file names can't contain double quotes, and cmd expands
+		// %VAR% even inside them unless the percent sign is escaped outside
+		return `"` + strings.ReplaceAll(s, "%", `"^%"`) + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// compiledHook is a rule prepared for matching and running
+type compiledHook struct {
+	HookRule
+	match ignoreRule
+
+	// running and waiting track MaxConcurrent: the runs in progress and
+	// those parked until one finishes
+	mu      sync.Mutex
+	running int
+	waiting []hookRun
+
+	command []*template.Template
+	env     map[string]*template.Template
+	move    *template.Template
+	copy    *template.Template
+
+	// bandwidth is the parsed BandwidthLimit in bytes per second
+	bandwidth int64
+}
+
+// parseHookTemplate parses one templated field of a rule. It is executed
+// once against empty data, so that referring to a field that doesn't exist
+// is reported when the rules are loaded rather than on every event. With
+// shell set every rendered value is quoted for the shell.
+func parseHookTemplate(name, text string, shell bool) (*template.Template, error) {
+	tmpl := template.New(name).Option("missingkey=error")
+	if shell {
+		tmpl = tmpl.Funcs(template.FuncMap{
+			"shellquote": func(value any) string { return shellQuote(fmt.Sprint(value)) },
+		})
+	}
+	tmpl, err := tmpl.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if shell {
+		quoteActions(tmpl.Tree.Root)
+	}
+	if err := tmpl.Execute(io.Discard, hookTemplateData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// expandHookTemplate renders a template for an event
+func expandHookTemplate(tmpl *template.Template, data hookTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// hookAction is a rule's action with its templates expanded for one event
+type hookAction struct {
+	Command []string
+	Env     []string
+	Move    string
+	Copy    string
+}
+
+// expand renders the rule's templates for an event
+func (h *compiledHook) expand(event Event) (hookAction, error) {
+	data := newHookTemplateData(event)
+
+	var action hookAction
+	for _, tmpl := range h.command {
+		arg, err := expandHookTemplate(tmpl, data)
+		if err != nil {
+			return hookAction{}, err
+		}
+		action.Command = append(action.Command, arg)
+	}
+
+	for name, tmpl := range h.env {
+		value, err := expandHookTemplate(tmpl, data)
+		if err != nil {
+			return hookAction{}, err
+		}
+		action.Env = append(action.Env, name+"="+value)
+	}
+
+	// Destinations are relative to the monitored directory
+	destination := func(tmpl *template.Template) (string, error) {
+		dest, err := expandHookTemplate(tmpl, data)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(event.Root, dest)
+		}
+		return filepath.Clean(dest), nil
+	}
+
+	var err error
+	if h.move != nil {
+		if action.Move, err = destination(h.move); err != nil {
+			return hookAction{}, err
+		}
+	}
+	if h.copy != nil {
+		if action.Copy, err = destination(h.copy); err != nil {
+			return hookAction{}, err
+		}
+	}
+	return action, nil
+}
+
+// matches reports whether the rule applies to an event
+func (h *compiledHook) matches(event Event) bool {
+	if len(h.Ops) > 0 {
+		found := false
+		for _, op := range h.Ops {
+			if strings.EqualFold(op, event.Op) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if h.match.re == nil {
+		return true
+	}
+	rel, err := filepath.Rel(event.Root, event.Path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return h.match.re.MatchString(filepath.ToSlash(rel)) != h.match.negate
+}
+
+// compileHooks validates rules and prepares them for matching
+func compileHooks(rules []HookRule) ([]*compiledHook, error) {
+	var hooks []*compiledHook
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("hook %d", i+1)
+		}
+		actions := 0
+		for _, set := range []bool{len(rule.Command) > 0, rule.Move != "", rule.Copy != ""} {
+			if set {
+				actions++
+			}
+		}
+		if actions != 1 {
+			return nil, fmt.Errorf("hook %s needs exactly one of a command, a move or a copy destination", rule.Name)
+		}
+
+		hook := &compiledHook{HookRule: rule, env: make(map[string]*template.Template)}
+		for i, arg := range rule.Command {
+			tmpl, err := parseHookTemplate(fmt.Sprintf("command[%d]", i), arg, rule.shell)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: %v", rule.Name, err)
+			}
+			hook.command = append(hook.command, tmpl)
+		}
+		for name, value := range rule.Env {
+			tmpl, err := parseHookTemplate("env "+name, value, false)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: %v", rule.Name, err)
+			}
+			hook.env[name] = tmpl
+		}
+		if rule.Move != "" {
+			tmpl, err := parseHookTemplate("move", rule.Move, false)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: %v", rule.Name, err)
+			}
+			hook.move = tmpl
+		}
+		if rule.Copy != "" {
+			tmpl, err := parseHookTemplate("copy", rule.Copy, false)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: %v", rule.Name, err)
+			}
+			hook.copy = tmpl
+		}
+		if rule.BandwidthLimit != "" {
+			bandwidth, err := parseByteRate(rule.BandwidthLimit)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: %v", rule.Name, err)
+			}
+			hook.bandwidth = bandwidth
+		}
+
+		if rule.Match != "" {
+			match, ok := parseIgnoreLine(rule.Match)
+			if !ok {
+				return nil, fmt.Errorf("hook %s has an invalid match pattern %q", rule.Name, rule.Match)
+			}
+			hook.match = match
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// hookRun is a pending execution of a rule for an event
+type hookRun struct {
+	hook    *compiledHook
+	event   Event
+	attempt int
+}
+
+// acquire takes one of the rule's MaxConcurrent slots for a run. When they
+// are all in use the run is parked with the rule instead, so that the worker
+// is free for other rules; if too many runs are parked already, it is
+// neither acquired nor parked.
+func (h *compiledHook) acquire(run hookRun) (acquired, parked bool) {
+	if h.MaxConcurrent <= 0 {
+		return true, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.running < h.MaxConcurrent {
+		h.running++
+		return true, false
+	}
+	if len(h.waiting) >= hookQueueSize {
+		return false, false
+	}
+	h.waiting = append(h.waiting, run)
+	return false, true
+}
+
+// release gives up a slot taken by acquire. If runs are parked, the slot
+// passes to the first of them, which is returned for the caller to execute.
+func (h *compiledHook) release() (hookRun, bool) {
+	if h.MaxConcurrent <= 0 {
+		return hookRun{}, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.waiting) > 0 {
+		next := h.waiting[0]
+		h.waiting = h.waiting[1:]
+		return next, true
+	}
+	h.running--
+	return hookRun{}, false
+}
+
+// hookRunner executes hook commands on a pool of workers, separate from the
+// event pipeline: events are queued without waiting, every run is bounded by
+// its rule's timeout, and each attempt is written to the audit log.
+type hookRunner struct {
+	hooks []*compiledHook
+	queue chan hookRun
+	audit *auditLog
+	wg    sync.WaitGroup
+
+	// pending counts runs not finished yet, including ones parked for a
+	// rule's slot or waiting to be retried
+	pending sync.WaitGroup
+}
+
+// newHookRunner starts workers for the given rules. It returns nil when
+// there are no rules, which Dispatch and Close accept.
+func newHookRunner(rules []HookRule, workers int) (*hookRunner, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	hooks, err := compileHooks(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if workers < 1 {
+		workers = defaultHookWorkers
+	}
+
+	runner := &hookRunner{
+		hooks: hooks,
+		queue: make(chan hookRun, hookQueueSize),
+		audit: &auditLog{},
+	}
+	for i := 0; i < workers; i++ {
+		runner.wg.Add(1)
+		go runner.work()
+	}
+	return runner, nil
+}
+
+// Dispatch queues the rules matching an event without blocking. Runs that
+// don't fit in the queue are dropped and logged.
+func (r *hookRunner) Dispatch(event Event) {
+	if r == nil {
+		return
+	}
+
+	for _, hook := range r.hooks {
+		if !hook.matches(event) {
+			continue
+		}
+
+		run := hookRun{hook: hook, event: event, attempt: 1}
+		r.pending.Add(1)
+		select {
+		case r.queue <- run:
+		default:
+			r.drop(run)
+		}
+	}
+}
+
+// Close waits for queued and running hooks, and their retries, to finish
+func (r *hookRunner) Close() {
+	if r == nil {
+		return
+	}
+
+	r.pending.Wait()
+	close(r.queue)
+	r.wg.Wait()
+}
+
+// work executes queued runs until the queue is closed. A run whose rule is
+// at its MaxConcurrent limit is parked rather than waited for; whichever
+// worker frees the rule's slot runs it next.
+func (r *hookRunner) work() {
+	defer r.wg.Done()
+
+	for run := range r.queue {
+		acquired, parked := run.hook.acquire(run)
+		if !acquired {
+			if !parked {
+				r.drop(run)
+			}
+			continue
+		}
+		for {
+			r.execute(run)
+			next, ok := run.hook.release()
+			if !ok {
+				break
+			}
+			run = next
+		}
+	}
+}
+
+// drop skips a run because too many are waiting, and logs it
+func (r *hookRunner) drop(run hookRun) {
+	fmt.Printf("[ERROR] hook queue full, skipping %s for %s\n", run.hook.Name, run.event.Path)
+	r.audit.Write(auditEntry{Time: time.Now(), Hook: run.hook.Name, Path: run.event.Path, Op: run.event.Op, Error: "dropped, hook queue full"})
+	r.pending.Done()
+}
+
+// execute runs one attempt of a hook. A failed attempt is requeued after
+// the rule's retry delay, so that waiting doesn't hold up a worker.
+func (r *hookRunner) execute(run hookRun) {
+	hook := run.hook
+	entry := runHook(hook, run.event)
+	entry.Attempt = run.attempt
+	r.audit.Write(entry)
+	if entry.Error == "" && entry.Reclaimed > 0 {
+		recordSavings(savingsEntry{Time: entry.Time, Action: "hook", Rule: hook.Name,
+			Dir: run.event.Root, Files: 1, Bytes: entry.Reclaimed})
+	}
+
+	switch {
+	case entry.Error == "" && entry.Dest != "":
+		verb := "moved"
+		if hook.copy != nil {
+			verb = "copied"
+		}
+		fmt.Printf("[HOOK] %s - %s: %s to %s\n", hook.Name, filepath.Base(run.event.Path), verb, entry.Dest)
+	case entry.Error == "":
+		fmt.Printf("[HOOK] %s - %s: ok (%s)\n", hook.Name, filepath.Base(run.event.Path), entry.duration().Round(time.Millisecond))
+	case run.attempt > hook.Retries:
+		fmt.Printf("[HOOK] %s - %s: %s\n", hook.Name, filepath.Base(run.event.Path), entry.Error)
+	default:
+		// The queue is only closed once pending runs are done, so the send
+		// can't race with Close
+		retry := hookRun{hook: hook, event: run.event, attempt: run.attempt + 1}
+		time.AfterFunc(hook.retryDelay(run.attempt), func() { r.queue <- retry })
+		return
+	}
+	r.pending.Done()
+}
+
+// runHook runs a rule's action once for an event and describes the outcome
+// as an audit entry
+func runHook(hook *compiledHook, event Event) (entry auditEntry) {
+	entry = auditEntry{
+		Time: time.Now(),
+		Hook: hook.Name,
+		Path: event.Path,
+		Op:   event.Op,
+	}
+
+	action, err := hook.expand(event)
+	if err != nil {
+		entry.Error = fmt.Sprintf("expanding templates: %v", err)
+		return entry
+	}
+
+	// Moving a file to another filesystem frees its space. Other actions
+	// don't: copies keep the file, moves within the monitored directory or
+	// its filesystem just relocate it, and a command's effect is unknown.
+	var sizeBefore int64
+	if info, err := os.Lstat(event.Path); err == nil && info.Mode().IsRegular() {
+		sizeBefore = info.Size()
+	}
+	defer func() {
+		if entry.Error != "" || action.Move == "" || sizeBefore == 0 {
+			return
+		}
+		if isAncestorPath(event.Root, action.Move) || sameFilesystem(action.Move, event.Root) {
+			return
+		}
+		if _, err := os.Lstat(event.Path); err != nil {
+			entry.Reclaimed = sizeBefore
+		}
+	}()
+
+	if action.Move != "" || action.Copy != "" {
+		opts := copyOptions{BytesPerSecond: hook.bandwidth}
+		if action.Move != "" {
+			entry.Dest = action.Move
+			err = moveFile(event.Path, action.Move, opts)
+		} else {
+			entry.Dest = action.Copy
+			err = copyFile(event.Path, action.Copy, opts)
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		entry.DurationMS = time.Since(entry.Time).Milliseconds()
+		return entry
+	}
+
+	runHookCommand(hook.HookRule, action, event, &entry)
+	return entry
+}
+
+// runHookCommand runs an expanded command once for an event, filling in the
+// outcome in entry
+func runHookCommand(rule HookRule, action hookAction, event Event, entry *auditEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), rule.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...)
+	cmd.Dir = event.Root
+	cmd.Env = append(os.Environ(),
+		"DIRMON_EVENT_PATH="+event.Path,
+		"DIRMON_EVENT_ROOT="+event.Root,
+		"DIRMON_EVENT_OP="+event.Op,
+		"DIRMON_EVENT_TIME="+event.Time.Format(time.RFC3339Nano),
+	)
+	cmd.Env = append(cmd.Env, action.Env...)
+
+	stdout := &limitedBuffer{limit: hookOutputLimit}
+	stderr := &limitedBuffer{limit: hookOutputLimit}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hookKillDelay
+	configureHookProcess(cmd)
+
+	err := cmd.Run()
+	entry.DurationMS = time.Since(entry.Time).Milliseconds()
+	entry.Stdout = stdout.String()
+	entry.Stderr = stderr.String()
+	if cmd.ProcessState != nil {
+		entry.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		entry.TimedOut = true
+		entry.Error = fmt.Sprintf("timed out after %s", rule.timeout())
+	case err != nil:
+		entry.Error = err.Error()
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a chatty hook can't exhaust memory
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
+
+// auditEntry records one attempt to run a hook
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Hook       string    `json:"hook"`
+	Path       string    `json:"path"`
+	Op         string    `json:"op"`
+	Dest       string    `json:"dest,omitempty"`
+	Attempt    int       `json:"attempt,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+	DurationMS int64     `json:"duration_ms"`
+	TimedOut   bool      `json:"timed_out,omitempty"`
+	Reclaimed  int64     `json:"reclaimed,omitempty"`
+	Stdout     string    `json:"stdout,omitempty"`
+	Stderr     string    `json:"stderr,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (e auditEntry) duration() time.Duration {
+	return time.Duration(e.DurationMS) * time.Millisecond
+}
+
+// auditLog appends hook runs to the state store's audit log as JSON
+// records, ~/.dirmon/audit.log with the file store
+type auditLog struct {
+	mu sync.Mutex
+}
+
+// Write appends an entry, reporting (but not failing on) errors
+func (a *auditLog) Write(entry auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = state.Append("audit", data)
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] writing audit log: %v\n", err)
+	}
+}
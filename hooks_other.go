@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// configureHookProcess keeps the default of killing only the hook process
+// itself on timeout, as there are no POSIX process groups
+func configureHookProcess(cmd *exec.Cmd) {}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ignoreFileName is the per-directory ignore file, using gitignore syntax
+const ignoreFileName = ".dirmonignore"
+
+// ignoreRule is a single compiled pattern from an ignore file
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreMatcher evaluates .dirmonignore files found below a root directory.
+// Rules are loaded lazily per directory and apply relative to the directory
+// containing the ignore file, the same way nested .gitignore files do.
+type ignoreMatcher struct {
+	root  string
+	mu    sync.Mutex
+	rules map[string][]ignoreRule
+}
+
+// newIgnoreMatcher creates a matcher for the tree rooted at root
+func newIgnoreMatcher(root string) *ignoreMatcher {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+	return &ignoreMatcher{
+		root:  absRoot,
+		rules: make(map[string][]ignoreRule),
+	}
+}
+
+// Match reports whether path (absolute or relative to the working directory)
+// is excluded by any ignore file between the root and the path itself
+func (m *ignoreMatcher) Match(path string, isDir bool) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(m.root, absPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	// A path is ignored if any of its parent directories is ignored,
+	// since gitignore does not allow re-including files below them
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matchOne(parts[:i], true) {
+			return true
+		}
+	}
+	return m.matchOne(parts, isDir)
+}
+
+// matchOne evaluates the rules of every ignore file from the root down to the
+// parent of the given path; the last matching rule wins
+func (m *ignoreMatcher) matchOne(parts []string, isDir bool) bool {
+	ignored := false
+	dir := m.root
+	for i := 0; i < len(parts); i++ {
+		rel := strings.Join(parts[i:], "/")
+		for _, rule := range m.rulesFor(dir) {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+// rulesFor returns the parsed ignore rules of a single directory
+func (m *ignoreMatcher) rulesFor(dir string) []ignoreRule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules, ok := m.rules[dir]
+	if !ok {
+		rules = loadIgnoreFile(filepath.Join(dir, ignoreFileName))
+		m.rules[dir] = rules
+	}
+	return rules
+}
+
+// Invalidate drops the cached rules of a directory so that an edited
+// ignore file is picked up by the next match
+func (m *ignoreMatcher) Invalidate(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.rules, dir)
+}
+
+// loadIgnoreFile parses an ignore file, returning no rules if it doesn't exist
+func loadIgnoreFile(path string) []ignoreRule {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// parseIgnoreLine compiles one line of gitignore syntax
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// Patterns containing a slash are relative to the ignore file's
+	// directory, others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globToRegexp translates a gitignore glob into a regular expression
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				sb.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// isIgnoredEvent reports whether a watcher event should be suppressed,
+// reloading the directory's rules when its ignore file itself changes
+func isIgnoredEvent(m *ignoreMatcher, event fsnotify.Event) bool {
+	if filepath.Base(event.Name) == ignoreFileName {
+		m.Invalidate(filepath.Dir(event.Name))
+	}
+
+	isDir := false
+	if info, err := os.Stat(event.Name); err == nil {
+		isDir = info.IsDir()
+	}
+	return m.Match(event.Name, isDir)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// initProfile holds the settings init proposes for a kind of directory
+type initProfile struct {
+	// MaxFiles is the proposed file limit, 0 for none
+	MaxFiles int64
+
+	// KeepDays is how long events of the directory are kept, 0 for the
+	// global policy
+	KeepDays int
+}
+
+// initProfiles are the proposals per kind of directory. Temporary and log
+// directories churn a lot, so their events are kept briefly and their file
+// counts capped; project trees are large by nature and only monitored.
+var initProfiles = map[string]initProfile{
+	"downloads": {MaxFiles: 10000, KeepDays: 30},
+	"logs":      {MaxFiles: 20000, KeepDays: 14},
+	"tmp":       {MaxFiles: 50000, KeepDays: 7},
+	"projects":  {},
+	"custom":    {},
+}
+
+// defaultInitRetention is proposed when no global retention policy is set
+var defaultInitRetention = RetentionPolicy{MaxAgeDays: 90, MaxSizeMB: 500}
+
+// initCandidate is a directory init proposes to monitor
+type initCandidate struct {
+	Kind string
+	Path string
+}
+
+// describe returns the settings proposed for the candidate
+func (c initCandidate) describe() string {
+	profile := initProfiles[c.Kind]
+
+	var settings []string
+	if profile.MaxFiles > 0 {
+		settings = append(settings, fmt.Sprintf("file limit %d", profile.MaxFiles))
+	}
+	if profile.KeepDays > 0 {
+		settings = append(settings, fmt.Sprintf("events kept %d days", profile.KeepDays))
+	}
+	if len(settings) == 0 {
+		return c.Kind
+	}
+	return c.Kind + ": " + strings.Join(settings, ", ")
+}
+
+// detectInitCandidates returns the common directories that exist on this
+// machine: downloads, logs, temp and project directories
+func detectInitCandidates() []initCandidate {
+	homeDir, _ := os.UserHomeDir()
+
+	candidates := map[string][]string{
+		"downloads": {filepath.Join(homeDir, "Downloads")},
+		"logs":      {filepath.Join(homeDir, "logs"), filepath.Join(homeDir, "log")},
+		"tmp":       {os.TempDir()},
+		"projects": {
+			filepath.Join(homeDir, "projects"), filepath.Join(homeDir, "Projects"),
+			filepath.Join(homeDir, "src"), filepath.Join(homeDir, "code"),
+			filepath.Join(homeDir, "dev"), filepath.Join(homeDir, "workspace"),
+			filepath.Join(homeDir, "repos"), filepath.Join(homeDir, "go", "src"),
+		},
+	}
+	if dir := os.Getenv("XDG_DOWNLOAD_DIR"); dir != "" {
+		candidates["downloads"] = append([]string{dir}, candidates["downloads"]...)
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		candidates["logs"] = append(candidates["logs"], filepath.Join(homeDir, "Library", "Logs"))
+	case "windows":
+	default:
+		candidates["logs"] = append(candidates["logs"], "/var/log")
+	}
+
+	var found []initCandidate
+	seen := make(map[string]bool)
+	for _, kind := range []string{"downloads", "logs", "tmp", "projects"} {
+		for _, path := range candidates[kind] {
+			resolved := canonicalPath(path)
+			if seen[resolved] || homeDir == "" && !filepath.IsAbs(path) {
+				continue
+			}
+
+			// Only propose directories that can actually be watched
+			info, err := os.Stat(path)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			if _, err := os.ReadDir(path); err != nil {
+				continue
+			}
+
+			seen[resolved] = true
+			found = append(found, initCandidate{Kind: kind, Path: path})
+		}
+	}
+	return found
+}
+
+// initOptions holds the optional behaviour of the init command
+type initOptions struct {
+	// Yes accepts every proposal without asking
+	Yes bool
+
+	// Dirs are extra directories to monitor
+	Dirs []string
+
+	// DryRun prints the resulting configuration instead of saving it
+	DryRun bool
+}
+
+// applyInitCandidate adds a directory with its kind's settings to config,
+// keeping any settings the directory already has
+func applyInitCandidate(config *Config, candidate initCandidate) error {
+	absPath, err := filepath.Abs(candidate.Path)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, dir := range config.MonitoredDirs {
+		if dir == absPath {
+			found = true
+			break
+		}
+	}
+	if !found {
+		config.MonitoredDirs = append(config.MonitoredDirs, absPath)
+	}
+
+	profile := initProfiles[candidate.Kind]
+	if _, ok := config.FileQuotas[absPath]; !ok && profile.MaxFiles > 0 {
+		if config.FileQuotas == nil {
+			config.FileQuotas = make(map[string]int64)
+		}
+		config.FileQuotas[absPath] = profile.MaxFiles
+	}
+	if _, ok := config.DirRetention[absPath]; !ok && profile.KeepDays > 0 {
+		if config.DirRetention == nil {
+			config.DirRetention = make(map[string]RetentionPolicy)
+		}
+		config.DirRetention[absPath] = RetentionPolicy{MaxAgeDays: profile.KeepDays}
+	}
+	return nil
+}
+
+// initConfig detects candidate directories, asks which to monitor unless
+// opts.Yes is set, and writes a starter configuration. An existing
+// configuration is extended, never replaced.
+func initConfig(opts initOptions) error {
+	reader := bufio.NewReader(os.Stdin)
+	ask := func(question string, defaultYes bool) bool {
+		if opts.Yes {
+			return true
+		}
+		if defaultYes {
+			fmt.Print(question + " (Y/n): ")
+		} else {
+			fmt.Print(question + " (y/N): ")
+		}
+		response, _ := reader.ReadString('\n')
+		response = strings.ToLower(strings.TrimSpace(response))
+		if response == "" {
+			return defaultYes
+		}
+		return response == "y" || response == "yes"
+	}
+
+	// Work on a copy so a dry run leaves the loaded configuration alone
+	config := appConfig
+	config.MonitoredDirs = slices.Clone(appConfig.MonitoredDirs)
+	config.FileQuotas = maps.Clone(appConfig.FileQuotas)
+	config.DirRetention = maps.Clone(appConfig.DirRetention)
+	if _, err := state.Get("config", "config"); err == nil {
+		fmt.Printf("Existing configuration found at %s, new settings will be added to it\n\n", configFile)
+	}
+
+	candidates := detectInitCandidates()
+	if len(candidates) > 0 {
+		fmt.Println("Found these directories worth monitoring:")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, candidate := range candidates {
+			fmt.Printf("%-45s %s\n", truncateString(candidate.Path, 44), candidate.describe())
+		}
+		fmt.Println(strings.Repeat("-", 80))
+	} else {
+		fmt.Println("No common directories found to propose")
+	}
+
+	var chosen []initCandidate
+	for _, candidate := range candidates {
+		if ask(fmt.Sprintf("Monitor %s (%s)?", candidate.Path, candidate.describe()), true) {
+			chosen = append(chosen, candidate)
+		}
+	}
+
+	for _, dir := range opts.Dirs {
+		chosen = append(chosen, initCandidate{Kind: "custom", Path: dir})
+	}
+	if !opts.Yes {
+		fmt.Print("Other directories to monitor (comma-separated, or press Enter to skip): ")
+		line, _ := reader.ReadString('\n')
+		for _, dir := range strings.Split(line, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				chosen = append(chosen, initCandidate{Kind: "custom", Path: dir})
+			}
+		}
+	}
+
+	for _, candidate := range chosen {
+		if candidate.Kind == "custom" {
+			info, err := os.Stat(candidate.Path)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", candidate.Path)
+			}
+		}
+		if err := applyInitCandidate(&config, candidate); err != nil {
+			return err
+		}
+	}
+
+	if config.Retention == (RetentionPolicy{}) &&
+		ask(fmt.Sprintf("Keep events and snapshots for %d days and at most %d MB per event store?",
+			defaultInitRetention.MaxAgeDays, defaultInitRetention.MaxSizeMB), true) {
+		config.Retention = defaultInitRetention
+	}
+
+	if opts.DryRun {
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nConfiguration that would be written to %s:\n%s\n", configFile, data)
+		return nil
+	}
+
+	if !opts.Yes && !ask(fmt.Sprintf("\nWrite the configuration to %s?", configFile), false) {
+		fmt.Println("Operation cancelled")
+		return nil
+	}
+
+	appConfig = config
+	if err := saveConfig(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Configuration written to %s (%d monitored directories)\n", configFile, len(config.MonitoredDirs))
+	fmt.Println("Start monitoring with: dirmon monitor-all")
+	return nil
+}
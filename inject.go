@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// eventOps are the event types the monitor commands produce
+var eventOps = []string{"CREATED", "MODIFIED", "DELETED", "RENAMED", "CHMOD"}
+
+// injectEvent feeds a synthetic event through the same pipeline as the
+// monitor commands, recording it and running matching hooks, without
+// touching the file. It waits for the hooks to finish.
+func injectEvent(path, op, root, detail string, opts monitorOptions) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	op = strings.ToUpper(op)
+	valid := false
+	for _, known := range eventOps {
+		if op == known {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("unknown event type %s, use one of %s", op, strings.Join(eventOps, ", "))
+	}
+
+	// Attribute the event like monitor-all would, unless told otherwise
+	if root == "" {
+		root = newWatchRoots(appConfig.MonitoredDirs).Attribute(absPath)
+	}
+	if root == "" {
+		root = filepath.Dir(absPath)
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return err
+	}
+
+	recorder, err := newEventRecorder(opts)
+	if err != nil {
+		return err
+	}
+	defer recorder.Close()
+
+	hooks, err := newHookRunner(opts.hookRules(), opts.HookWorkers)
+	if err != nil {
+		return err
+	}
+
+	event := Event{Time: time.Now(), Root: root, Path: absPath, Op: op, Detail: detail}
+	fmt.Printf("[%s] [%s] %s - %s (injected)\n", event.Time.Format("15:04:05"), root, op, filepath.Base(absPath))
+
+	recorder.Record(event)
+	hooks.Dispatch(event)
+	hooks.Close()
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maxRunningJobs limits how many scans a server runs at once; further
+	// jobs wait in the queued state
+	maxRunningJobs = 2
+
+	// finishedJobTTL is how long finished jobs and their results are kept
+	finishedJobTTL = 24 * time.Hour
+)
+
+// Job states
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// jobFunc runs a job of one type
+type jobFunc func(ctx context.Context, id, absPath string, progress scanProgress) (any, error)
+
+// jobTypes maps the scan types that can run as jobs to their implementation
+var jobTypes = map[string]jobFunc{
+	"find-duplicates": func(ctx context.Context, id, absPath string, progress scanProgress) (any, error) {
+		// Jobs keep their checkpoint under their own key, so concurrent jobs
+		// and the CLI's --resume don't share it. Jobs are never resumed, so
+		// it is removed however the scan ends.
+		checkpoint := newDuplicateCheckpoint(absPath)
+		checkpoint.key = checkpointKey("job-"+id, absPath)
+		groups, err := scanDuplicates(ctx, absPath, checkpoint, progress)
+		checkpoint.remove()
+		return groups, err
+	},
+	"disk-usage": func(ctx context.Context, id, absPath string, progress scanProgress) (any, error) {
+		usage, err := collectDiskUsage(ctx, absPath, diskUsageOptions{}, progress)
+		if err != nil {
+			return nil, err
+		}
+		return usage.report(50), nil
+	},
+}
+
+// JobProgress describes how far a running job has come
+type JobProgress struct {
+	Phase string `json:"phase,omitempty"`
+	Done  int    `json:"done"`
+	Total int    `json:"total,omitempty"`
+}
+
+// Job is a scan running asynchronously in server mode
+type Job struct {
+	ID       string      `json:"id"`
+	Type     string      `json:"type"`
+	Path     string      `json:"path"`
+	State    string      `json:"state"`
+	Progress JobProgress `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+
+	result any
+	cancel context.CancelFunc
+}
+
+// jobManager runs jobs and keeps their state and results in memory
+type jobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	slots chan struct{}
+}
+
+// newJobManager creates an empty job manager
+func newJobManager() *jobManager {
+	return &jobManager{
+		jobs:  make(map[string]*Job),
+		slots: make(chan struct{}, maxRunningJobs),
+	}
+}
+
+// Start queues a new job and returns a snapshot of it
+func (m *jobManager) Start(jobType, path string) (Job, error) {
+	run, ok := jobTypes[jobType]
+	if !ok {
+		return Job{}, fmt.Errorf("unknown job type %q", jobType)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return Job{}, err
+	}
+	if info, err := os.Stat(absPath); err != nil {
+		return Job{}, err
+	} else if !info.IsDir() {
+		return Job{}, fmt.Errorf("%s is not a directory", absPath)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:      newJobID(),
+		Type:    jobType,
+		Path:    absPath,
+		State:   jobQueued,
+		Created: time.Now(),
+		cancel:  cancel,
+	}
+
+	m.mu.Lock()
+	m.removeExpired()
+	m.jobs[job.ID] = job
+	snapshot := *job
+	m.mu.Unlock()
+
+	go m.run(ctx, job, run)
+	return snapshot, nil
+}
+
+// run waits for a free slot and executes the job
+func (m *jobManager) run(ctx context.Context, job *Job, run jobFunc) {
+	typeAttr := attribute.String("type", job.Type)
+	ctx, span := tracer.Start(ctx, "job "+job.Type, trace.WithAttributes(
+		attribute.String("dirmon.job.id", job.ID),
+		attribute.String("dirmon.root", job.Path),
+	))
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		m.finish(job, nil, ctx.Err())
+		endSpan(span, ctx.Err())
+		return
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	job.State = jobRunning
+	job.Started = &now
+	m.mu.Unlock()
+
+	queued := now.Sub(job.Created)
+	jobQueueDuration.Record(ctx, queued.Seconds(), metric.WithAttributes(typeAttr))
+	span.AddEvent("started", trace.WithAttributes(attribute.Float64("dirmon.job.queued_seconds", queued.Seconds())))
+
+	result, err := run(ctx, job.ID, job.Path, func(phase string, done, total int) {
+		m.mu.Lock()
+		job.Progress = JobProgress{Phase: phase, Done: done, Total: total}
+		m.mu.Unlock()
+	})
+	state := m.finish(job, result, err)
+
+	jobDuration.Record(ctx, secondsSince(now), metric.WithAttributes(typeAttr, attribute.String("state", state)))
+	endSpan(span, err)
+}
+
+// finish records the outcome of a job and returns its final state
+func (m *jobManager) finish(job *Job, result any, err error) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	job.Finished = &now
+	job.cancel()
+
+	switch {
+	case err == context.Canceled:
+		job.State = jobCanceled
+	case err != nil:
+		job.State = jobFailed
+		job.Error = err.Error()
+	default:
+		job.State = jobDone
+		job.result = result
+	}
+	return job.State
+}
+
+// Get returns a snapshot of a job
+func (m *jobManager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Result returns the result of a finished job
+func (m *jobManager) Result(id string) (any, Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, Job{}, false
+	}
+	return job.result, *job, true
+}
+
+// List returns snapshots of all jobs, newest first
+func (m *jobManager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.After(jobs[j].Created)
+	})
+	return jobs
+}
+
+// Cancel stops a queued or running job
+func (m *jobManager) Cancel(id string) (Job, bool) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Job{}, false
+	}
+
+	job.cancel()
+	return m.Get(id)
+}
+
+// removeExpired drops finished jobs older than finishedJobTTL; the caller
+// must hold m.mu
+func (m *jobManager) removeExpired() {
+	for id, job := range m.jobs {
+		if job.Finished != nil && time.Since(*job.Finished) > finishedJobTTL {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// newJobID returns a random job identifier
+func newJobID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
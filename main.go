@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -9,10 +10,9 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"sort"
 	"strings"
 	"time"
 
@@ -22,7 +22,13 @@ import (
 
 // Config stores the application configuration
 type Config struct {
-	MonitoredDirs []string `json:"monitored_dirs"`
+	MonitoredDirs []string                   `json:"monitored_dirs"`
+	Retention     RetentionPolicy            `json:"retention"`
+	DirRetention  map[string]RetentionPolicy `json:"dir_retention,omitempty"`
+	APITokens     []APIToken                 `json:"api_tokens,omitempty"`
+	Hooks         []HookRule                 `json:"hooks,omitempty"`
+	MasterDirs    []string                   `json:"master_dirs,omitempty"`
+	FileQuotas    map[string]int64           `json:"file_quotas,omitempty"`
 }
 
 // Global variables
@@ -32,30 +38,110 @@ var (
 )
 
 func main() {
-	// Load configuration
+	// Open the state store and load the configuration from it
+	var err error
+	state, err = openStateStore(os.Getenv("DIRMON_STATE"))
+	if err != nil {
+		log.Fatalf("Error opening the state store: %v", err)
+	}
 	loadConfig()
 
+	shutdownTelemetry := func(context.Context) error { return nil }
+
 	app := &cli.App{
 		Name:  "dirmon",
 		Usage: "Monitor directories and manage files",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "remote",
+				Usage:   "Run the command against a dirmon server at `HOST:PORT`",
+				EnvVars: []string{"DIRMON_REMOTE"},
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Usage:   "API token for --remote",
+				EnvVars: []string{"DIRMON_TOKEN"},
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if err := checkRemoteCommand(c); err != nil {
+				return err
+			}
+
+			shutdown, err := setupTelemetry(c.Context)
+			if err != nil {
+				return fmt.Errorf("setting up telemetry: %v", err)
+			}
+			shutdownTelemetry = shutdown
+			return nil
+		},
+		After: func(c *cli.Context) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTelemetry(ctx); err != nil {
+				fmt.Printf("[ERROR] flushing telemetry: %v\n", err)
+			}
+			return nil
+		},
 		Commands: []*cli.Command{
 			{
 				Name:    "interactive",
 				Aliases: []string{"i"},
 				Usage:   "Run in interactive mode",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "no-clear",
+						Usage: "Don't clear the screen between menus, keeping earlier output in the scrollback",
+					},
+				},
 				Action: func(c *cli.Context) error {
-					return runInteractiveMode()
+					return runInteractiveMode(c.Bool("no-clear"))
+				},
+			},
+			{
+				Name:  "init",
+				Usage: "Detect common directories and write a starter configuration",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "dir",
+						Usage: "Also monitor this directory (can be repeated)",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Print the configuration instead of writing it",
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Accept every proposal without asking",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return initConfig(initOptions{
+						Yes:    c.Bool("yes"),
+						Dirs:   c.StringSlice("dir"),
+						DryRun: c.Bool("dry-run"),
+					})
 				},
 			},
 			{
 				Name:    "list",
 				Aliases: []string{"ls"},
 				Usage:   "List directory contents",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Output the listing as JSON",
+					},
+				},
 				Action: func(c *cli.Context) error {
 					path := "."
 					if c.NArg() > 0 {
 						path = c.Args().Get(0)
 					}
+					if c.Bool("json") {
+						return listDirectoryJSON(path)
+					}
 					return listDirectory(path)
 				},
 			},
@@ -94,40 +180,744 @@ func main() {
 					return provideCleanupAdvice(path, c.Int("age"), c.Int("size"))
 				},
 			},
+			{
+				Name:  "system-clean",
+				Usage: "Find and clean reclaimable space in standard cache and temp locations",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "browsers",
+						Usage: "Include browser caches",
+					},
+					&cli.IntFlag{
+						Name:  "tmp-age",
+						Value: 7,
+						Usage: "Only clean temp files unused for this many days",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only report reclaimable space",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return systemClean(c.Bool("browsers"), c.Int("tmp-age"), c.Bool("dry-run"))
+				},
+			},
 			{
 				Name:    "find-duplicates",
 				Aliases: []string{"fd"},
 				Usage:   "Find duplicate files in a directory",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "resume",
+						Usage: "Resume an interrupted scan from its last checkpoint",
+					},
+					&cli.BoolFlag{
+						Name:  "delete-copies",
+						Usage: "Delete every copy except the original of each group",
+					},
+					&cli.BoolFlag{
+						Name:  "reflink",
+						Usage: "Make copies share the original's storage instead of deleting them (Linux, btrfs or XFS)",
+					},
+					&cli.BoolFlag{
+						Name:  "yes",
+						Usage: "Don't ask for confirmation before deleting or reflinking copies",
+					},
+				},
 				Action: func(c *cli.Context) error {
+					if c.Bool("delete-copies") && c.Bool("reflink") {
+						return fmt.Errorf("--delete-copies and --reflink cannot be combined")
+					}
+					if api := remoteClient(c); api != nil {
+						if c.Bool("resume") || c.Bool("delete-copies") || c.Bool("reflink") {
+							return fmt.Errorf("--resume, --delete-copies and --reflink cannot be used with --remote")
+						}
+						path, err := remotePath(c)
+						if err != nil {
+							return err
+						}
+						return remoteFindDuplicates(api, path)
+					}
+
 					path := "."
 					if c.NArg() > 0 {
 						path = c.Args().Get(0)
 					}
-					return findDuplicateFiles(path)
+					return findDuplicateFiles(path, duplicateOptions{
+						Resume:       c.Bool("resume"),
+						DeleteCopies: c.Bool("delete-copies"),
+						Reflink:      c.Bool("reflink"),
+						Yes:          c.Bool("yes"),
+					})
 				},
 			},
 			{
 				Name:    "disk-usage",
 				Aliases: []string{"du"},
 				Usage:   "Analyze disk usage in a directory",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all-monitored",
+						Usage: "Analyze all monitored directories concurrently",
+					},
+					&cli.IntFlag{
+						Name:  "workers",
+						Value: runtime.NumCPU(),
+						Usage: "Maximum number of directories scanned at once",
+					},
+					&cli.BoolFlag{
+						Name:  "dedup-aware",
+						Usage: "Also report the size of unique content, discounting duplicate copies",
+					},
+					&cli.BoolFlag{
+						Name:  "files",
+						Usage: "Report file counts instead of sizes, highlighting directories with many tiny files",
+					},
+				},
 				Action: func(c *cli.Context) error {
+					opts := diskUsageOptions{
+						AllMonitored: c.Bool("all-monitored"),
+						Workers:      c.Int("workers"),
+						DedupAware:   c.Bool("dedup-aware"),
+						Files:        c.Bool("files"),
+					}
+					if opts.Files && opts.DedupAware {
+						return fmt.Errorf("--files and --dedup-aware cannot be combined")
+					}
+					if api := remoteClient(c); api != nil {
+						if opts.AllMonitored || opts.DedupAware || opts.Files {
+							return fmt.Errorf("--all-monitored, --dedup-aware and --files cannot be used with --remote")
+						}
+						path, err := remotePath(c)
+						if err != nil {
+							return err
+						}
+						return remoteDiskUsage(api, path)
+					}
+
+					if opts.AllMonitored {
+						return analyzeMonitoredDiskUsage(opts)
+					}
+
 					path := "."
 					if c.NArg() > 0 {
 						path = c.Args().Get(0)
 					}
-					return analyzeDiskUsage(path)
+					return analyzeDiskUsage(path, opts)
 				},
 			},
 			{
 				Name:    "monitor",
 				Aliases: []string{"mon"},
 				Usage:   "Monitor a directory for changes",
+				Flags:   monitorFlags(),
+				Action: func(c *cli.Context) error {
+					path := "."
+					if c.NArg() > 0 {
+						path = c.Args().Get(0)
+					}
+					return monitorDirectory(path, monitorOptionsFromContext(c))
+				},
+			},
+			{
+				Name:  "track",
+				Usage: "Commit every change in a directory to a local git repository",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "diff",
+						Usage: "Print the full diff of each commit instead of a summary",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						return fmt.Errorf("please specify a directory to track")
+					}
+					return trackDirectory(c.Args().Get(0), c.Bool("diff"))
+				},
+			},
+			{
+				Name:  "events",
+				Usage: "Query recorded events",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "sql",
+						Usage:     "Run an SQL query against the event database",
+						ArgsUsage: "<query>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "db",
+								Usage: "SQLite event database to query (default ~/.dirmon/events.db)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if c.NArg() == 0 {
+								return fmt.Errorf("please specify a query")
+							}
+							if api := remoteClient(c); api != nil {
+								return remoteQueryEvents(api, c.Args().Get(0))
+							}
+
+							dbPath := c.String("db")
+							if dbPath == "" {
+								dbPath = defaultEventDBPath()
+							}
+							return queryEvents(dbPath, c.Args().Get(0))
+						},
+					},
+				},
+			},
+			{
+				Name:  "snapshot",
+				Usage: "Record directory sizes and alert on growth since the last snapshot",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all-monitored",
+						Usage: "Snapshot all monitored directories",
+					},
+					&cli.Float64Flag{
+						Name:  "alert-gb",
+						Usage: "Alert when a directory grew by more than this many GB",
+					},
+					&cli.Float64Flag{
+						Name:  "alert-percent",
+						Usage: "Alert when a directory grew by more than this percentage",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					thresholds := growthThresholds{
+						GB:      c.Float64("alert-gb"),
+						Percent: c.Float64("alert-percent"),
+					}
+					if thresholds.GB <= 0 && thresholds.Percent <= 0 {
+						fmt.Println("[WARNING] Neither --alert-gb nor --alert-percent is set, growth is reported but never alerted on")
+					}
+					if c.Bool("all-monitored") {
+						if len(appConfig.MonitoredDirs) == 0 {
+							return fmt.Errorf("no directories to snapshot")
+						}
+						return snapshotDirectories(appConfig.MonitoredDirs, thresholds)
+					}
+
+					path := "."
+					if c.NArg() > 0 {
+						path = c.Args().Get(0)
+					}
+					return snapshotDirectories([]string{path}, thresholds)
+				},
+			},
+			{
+				Name:  "retention",
+				Usage: "Show or change how long dirmon keeps events and other data",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "max-age",
+						Value: -1,
+						Usage: "Maximum age in days (0 for no limit)",
+					},
+					&cli.IntFlag{
+						Name:  "max-size",
+						Value: -1,
+						Usage: "Maximum size of each store in MB (0 for no limit)",
+					},
+					&cli.StringFlag{
+						Name:  "dir",
+						Usage: "Set the max age for events of a single monitored directory",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("max-age") && !c.IsSet("max-size") {
+						viewRetention()
+						return nil
+					}
+					return setRetention(c.String("dir"), c.Int("max-age"), c.Int("max-size"))
+				},
+			},
+			{
+				Name:  "prune",
+				Usage: "Remove data older or larger than the retention policy",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "event-log",
+						Usage: "JSON lines event log `FILE` to prune",
+					},
+					&cli.StringFlag{
+						Name:  "event-db",
+						Usage: "SQLite event database `FILE` to prune",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return pruneStores(c.String("event-log"), c.String("event-db"))
+				},
+			},
+			{
+				Name:  "serve",
+				Usage: "Run the HTTP API server for asynchronous scan jobs",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Value: "127.0.0.1:8080",
+						Usage: "Address to listen on",
+					},
+					&cli.StringFlag{
+						Name:  "event-db",
+						Usage: "SQLite event database served to clients (default ~/.dirmon/events.db)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					eventDB := c.String("event-db")
+					if eventDB == "" {
+						eventDB = defaultEventDBPath()
+					}
+					return runServer(c.String("addr"), eventDB)
+				},
+			},
+			{
+				Name:  "token",
+				Usage: "Manage API tokens for the server",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "create",
+						Usage:     "Create a token",
+						ArgsUsage: "<name>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "scope",
+								Value: scopeRead,
+								Usage: "What the token may do: read, scan or admin",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return createToken(c.Args().Get(0), c.String("scope"))
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List tokens",
+						Action: func(c *cli.Context) error {
+							listTokens()
+							return nil
+						},
+					},
+					{
+						Name:      "revoke",
+						Usage:     "Revoke a token",
+						ArgsUsage: "<name>",
+						Action: func(c *cli.Context) error {
+							if c.NArg() == 0 {
+								return fmt.Errorf("please specify a token name")
+							}
+							return revokeToken(c.Args().Get(0))
+						},
+					},
+				},
+			},
+			{
+				Name:      "names",
+				Usage:     "Report file names that cause problems across platforms, optionally renaming them",
+				ArgsUsage: "[path]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "fix",
+						Usage: "Rename files to the suggested portable names",
+					},
+					&cli.BoolFlag{
+						Name:  "yes",
+						Usage: "Don't ask for confirmation before renaming",
+					},
+					&cli.IntFlag{
+						Name:  "max-path",
+						Value: defaultMaxPathLength,
+						Usage: "Longest allowed path relative to the checked directory (0 for no limit)",
+					},
+				},
 				Action: func(c *cli.Context) error {
 					path := "."
 					if c.NArg() > 0 {
 						path = c.Args().Get(0)
 					}
-					return monitorDirectory(path)
+					return checkFileNames(path, nameOptions{
+						Fix:     c.Bool("fix"),
+						Yes:     c.Bool("yes"),
+						MaxPath: c.Int("max-path"),
+					})
+				},
+			},
+			{
+				Name:      "portability",
+				Usage:     "Check that a tree can be shared across platforms, exiting non-zero on problems",
+				ArgsUsage: "[path]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "max-path",
+						Value: defaultMaxPathLength,
+						Usage: "Longest allowed path relative to the checked directory (0 for no limit)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					path := "."
+					if c.NArg() > 0 {
+						path = c.Args().Get(0)
+					}
+					return checkFileNames(path, nameOptions{MaxPath: c.Int("max-path"), Strict: true})
+				},
+			},
+			{
+				Name:      "inject",
+				Usage:     "Feed a synthetic event through the event pipeline, for testing hooks and event stores",
+				ArgsUsage: "<path> <CREATED|MODIFIED|DELETED|RENAMED|CHMOD>",
+				Hidden:    true,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "root",
+						Usage: "Monitored directory the event belongs to (default: the monitored directory containing the path)",
+					},
+					&cli.StringFlag{
+						Name:  "detail",
+						Value: "injected",
+						Usage: "Detail recorded with the event, marking it as synthetic in event stores",
+					},
+					&cli.StringFlag{
+						Name:  "event-log",
+						Usage: "Append the event to `FILE` as JSON lines",
+					},
+					&cli.StringFlag{
+						Name:  "event-db",
+						Usage: "Store the event in the SQLite database `FILE`",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exec",
+						Usage: "Run shell `COMMAND` for the event, in addition to the hooks in the config (can be repeated)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 2 {
+						return fmt.Errorf("please specify a path and an event type")
+					}
+					// Only the stores and hooks apply to a single injected event
+					opts := monitorOptions{
+						EventLog: c.String("event-log"),
+						EventDB:  c.String("event-db"),
+						Exec:     c.StringSlice("exec"),
+					}
+					return injectEvent(c.Args().Get(0), c.Args().Get(1), c.String("root"), c.String("detail"), opts)
+				},
+			},
+			{
+				Name:      "replay",
+				Usage:     "Show which hooks would run for recorded events, without running them",
+				ArgsUsage: "<events.jsonl>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "rules",
+						Usage: "Test the hook rules in `FILE` instead of the configured ones",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exec",
+						Usage: "Also test an --exec shell `COMMAND` (can be repeated)",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Also list events no rule matches",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						return fmt.Errorf("please specify an event log recorded with --event-log, or - for stdin")
+					}
+					return replayEvents(c.Args().Get(0), c.String("rules"), c.StringSlice("exec"), c.Bool("all"))
+				},
+			},
+			{
+				Name:  "master",
+				Usage: "Manage master directories, whose files duplicate actions never delete",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "add",
+						Usage:     "Mark a directory as a master",
+						ArgsUsage: "<directory>",
+						Action: func(c *cli.Context) error {
+							if c.NArg() == 0 {
+								return fmt.Errorf("please specify a directory")
+							}
+							return addMasterDir(c.Args().Get(0))
+						},
+					},
+					{
+						Name:      "remove",
+						Usage:     "Stop treating a directory as a master",
+						ArgsUsage: "<directory>",
+						Action: func(c *cli.Context) error {
+							if c.NArg() == 0 {
+								return fmt.Errorf("please specify a directory")
+							}
+							return removeMasterDir(c.Args().Get(0))
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List master directories",
+						Action: func(c *cli.Context) error {
+							viewMasterDirs()
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:      "pack",
+				Usage:     "Bundle files not modified for a while into a compressed archive and delete them",
+				ArgsUsage: "<directory>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "older-than",
+						Value: "1y",
+						Usage: "Pack files not modified for this long, e.g. 90d, 6m or 1y",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Directory to write the archive to (default ~/.dirmon/packs)",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "List the files that would be packed",
+					},
+					&cli.BoolFlag{
+						Name:  "keep",
+						Usage: "Keep the original files",
+					},
+					&cli.StringFlag{
+						Name:  "bwlimit",
+						Usage: "Limit how fast files are read, e.g. 20MB per second",
+					},
+					&cli.BoolFlag{
+						Name:  "yes",
+						Usage: "Don't ask for confirmation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						return fmt.Errorf("please specify a directory")
+					}
+					var bandwidth int64
+					if c.String("bwlimit") != "" {
+						var err error
+						if bandwidth, err = parseByteRate(c.String("bwlimit")); err != nil {
+							return err
+						}
+					}
+					return packDirectory(c.Args().Get(0), packOptions{
+						OlderThan:      c.String("older-than"),
+						Output:         c.String("output"),
+						DryRun:         c.Bool("dry-run"),
+						Yes:            c.Bool("yes"),
+						Keep:           c.Bool("keep"),
+						BytesPerSecond: bandwidth,
+					})
+				},
+			},
+			{
+				Name:      "unpack",
+				Usage:     "Restore all files of a pack",
+				ArgsUsage: "<pack ID or archive>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Restore below this directory instead of the original paths",
+					},
+					&cli.BoolFlag{
+						Name:  "remove",
+						Usage: "Delete the archive once all files are restored",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						return fmt.Errorf("please specify a pack, see dirmon packs")
+					}
+					return unpackArchive(c.Args().Get(0), c.String("to"), c.Bool("remove"))
+				},
+			},
+			{
+				Name:      "extract",
+				Usage:     "Restore a packed file, or all packed files below a directory",
+				ArgsUsage: "<original path>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Restore below this directory instead of the original paths",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						return fmt.Errorf("please specify a path")
+					}
+					return extractPackedPath(c.Args().Get(0), c.String("to"))
+				},
+			},
+			{
+				Name:  "packs",
+				Usage: "List pack archives",
+				Action: func(c *cli.Context) error {
+					return viewPacks()
+				},
+			},
+			{
+				Name:  "savings",
+				Usage: "Show the space reclaimed by cleanup, dedupe, pack and hook actions",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "Only count actions within this period (e.g. 30d, 6m, 1y)",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Output the totals as JSON",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					var since time.Time
+					if c.IsSet("since") {
+						age, err := parseAge(c.String("since"))
+						if err != nil {
+							return err
+						}
+						since = time.Now().Add(-age)
+					}
+					return showSavings(since, c.Bool("json"))
+				},
+			},
+			{
+				Name:  "activity",
+				Usage: "Show who created, modified and deleted the most data in monitored directories",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "by-user",
+						Usage: "Group changes by user and process (recorded with monitor --attribute)",
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Value: "7d",
+						Usage: "Only count events within this period (e.g. 24h, 7d, 6m)",
+					},
+					&cli.StringFlag{
+						Name:  "root",
+						Usage: "Only count events in this monitored `DIR`",
+					},
+					&cli.StringFlag{
+						Name:  "db",
+						Usage: "SQLite event database to read (default ~/.dirmon/events.db)",
+					},
+					&cli.StringFlag{
+						Name:  "event-log",
+						Usage: "Read events from the JSON lines event log `FILE` instead",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Value: 20,
+						Usage: "Maximum number of rows to show (0 for all)",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Output the report as JSON",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					age, err := parseAge(c.String("since"))
+					if err != nil {
+						return err
+					}
+					opts := activityOptions{
+						Since:    time.Now().Add(-age),
+						Root:     c.String("root"),
+						EventDB:  c.String("db"),
+						EventLog: c.String("event-log"),
+						ByUser:   c.Bool("by-user"),
+						Limit:    c.Int("limit"),
+						JSON:     c.Bool("json"),
+					}
+					if opts.EventDB == "" {
+						opts.EventDB = defaultEventDBPath()
+					}
+					return showActivity(opts)
+				},
+			},
+			{
+				Name:  "state",
+				Usage: "Inspect, back up and move dirmon's own state (set with DIRMON_STATE)",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "info",
+						Usage: "Show where the state is kept and what it holds",
+						Action: func(c *cli.Context) error {
+							return showStateInfo()
+						},
+					},
+					{
+						Name:      "backup",
+						Usage:     "Copy the SQLite state store, including the event history, to a new file",
+						ArgsUsage: "<file>",
+						Action: func(c *cli.Context) error {
+							if c.NArg() == 0 {
+								return fmt.Errorf("please specify a backup file")
+							}
+							return backupState(c.Args().Get(0))
+						},
+					},
+					{
+						Name:  "migrate",
+						Usage: "Copy the state, logs and event history into another store",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "to",
+								Usage:    "Target store, `sqlite:FILE` or file:DIR",
+								Required: true,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return migrateState(c.String("to"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "quota",
+				Usage: "Manage limits on the number of files in directories",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "set",
+						Usage:     "Limit the number of files and directories below a directory",
+						ArgsUsage: "<directory>",
+						Flags: []cli.Flag{
+							&cli.Int64Flag{
+								Name:     "max-files",
+								Usage:    "Maximum number of files and directories",
+								Required: true,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if c.NArg() == 0 {
+								return fmt.Errorf("please specify a directory")
+							}
+							return setFileQuota(c.Args().Get(0), c.Int64("max-files"))
+						},
+					},
+					{
+						Name:      "remove",
+						Usage:     "Remove the file limit of a directory",
+						ArgsUsage: "<directory>",
+						Action: func(c *cli.Context) error {
+							if c.NArg() == 0 {
+								return fmt.Errorf("please specify a directory")
+							}
+							return removeFileQuota(c.Args().Get(0))
+						},
+					},
+					{
+						Name:  "check",
+						Usage: "Count files against the limits, exiting non-zero if any is exceeded",
+						Action: func(c *cli.Context) error {
+							return checkFileQuotas()
+						},
+					},
 				},
 			},
 			{
@@ -137,6 +927,9 @@ func main() {
 					if c.NArg() == 0 {
 						return fmt.Errorf("please specify a directory to add")
 					}
+					if api := remoteClient(c); api != nil {
+						return remoteAddDir(api, c.Args().Get(0))
+					}
 					return addDirectory(c.Args().Get(0))
 				},
 			},
@@ -144,6 +937,9 @@ func main() {
 				Name:  "show-dirs",
 				Usage: "Show all monitored directories",
 				Action: func(c *cli.Context) error {
+					if api := remoteClient(c); api != nil {
+						return remoteShowDirs(api)
+					}
 					viewMonitoredDirectories()
 					return nil
 				},
@@ -151,14 +947,20 @@ func main() {
 			{
 				Name:  "monitor-all",
 				Usage: "Monitor all saved directories",
+				Flags: monitorFlags(),
 				Action: func(c *cli.Context) error {
-					return monitorAllDirectories()
+					return monitorAllDirectories(monitorOptionsFromContext(c))
 				},
 			},
 		},
 	}
 
-	err := app.Run(os.Args)
+	// Close the store before exiting, which log.Fatal does without running
+	// deferred calls, so the SQLite store is flushed on errors too
+	err = app.Run(os.Args)
+	if closeErr := state.Close(); closeErr != nil {
+		fmt.Printf("[ERROR] closing the state store: %v\n", closeErr)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -166,21 +968,14 @@ func main() {
 
 // loadConfig loads the application configuration
 func loadConfig() {
-	// Look for config in /opt directory first
-	optConfigPath := "/opt/dirmon_config.json"
-
-	// Check if file exists in /opt
-	if _, err := os.Stat(optConfigPath); err == nil {
-		configFile = optConfigPath
-	} else {
-		// Fall back to user's home directory
-		homeDir, err := os.UserHomeDir()
-		if err == nil {
-			configFile = filepath.Join(homeDir, ".dirmon_config.json")
-		}
+	// The file store keeps the config in /opt/dirmon_config.json if it
+	// exists, otherwise in the user's home directory
+	configFile = state.Name()
+	if store, ok := state.(*fileStore); ok {
+		configFile = store.configPath
 	}
 
-	data, err := os.ReadFile(configFile)
+	data, err := state.Get("config", "config")
 	if err != nil {
 		// Config file doesn't exist yet - create empty config
 		appConfig = Config{
@@ -205,27 +1000,28 @@ func saveConfig() error {
 		return err
 	}
 
-	return os.WriteFile(configFile, data, 0644)
+	return state.Put("config", "config", data)
 }
 
-// clearScreen clears the terminal screen
-func clearScreen() {
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("cmd", "/c", "cls")
-	} else {
-		cmd = exec.Command("clear")
+// stateDir returns the directory holding dirmon's own data such as scan
+// checkpoints, creating it if necessary
+func stateDir() (string, error) {
+	dir := state.Dir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Run()
+	return dir, nil
 }
 
-// runInteractiveMode starts the interactive CLI mode
-func runInteractiveMode() error {
+// runInteractiveMode starts the interactive CLI mode. With noClear the
+// screen isn't cleared between menus, keeping earlier output in the
+// scrollback.
+func runInteractiveMode(noClear bool) error {
 	reader := bufio.NewReader(os.Stdin)
+	screen := newScreen(!noClear)
 
 	for {
-		clearScreen()
+		screen.Clear()
 		fmt.Println("===== Directory Monitor =====")
 		fmt.Println("1. List directory contents")
 		fmt.Println("2. Delete a file")
@@ -244,7 +1040,7 @@ func runInteractiveMode() error {
 		choice, _ := reader.ReadString('\n')
 		choice = strings.TrimSpace(choice)
 
-		clearScreen()
+		screen.Clear()
 
 		switch choice {
 		case "0":
@@ -289,7 +1085,7 @@ func runInteractiveMode() error {
 			}
 
 			fmt.Println("Monitoring directory. Press Ctrl+C to stop...")
-			err := monitorDirectory(path)
+			err := monitorDirectory(path, monitorOptions{})
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 			}
@@ -325,7 +1121,7 @@ func runInteractiveMode() error {
 
 		case "7":
 			fmt.Println("Monitoring all directories. Press Ctrl+C to stop...")
-			err := monitorAllDirectories()
+			err := monitorAllDirectories(monitorOptions{})
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 			}
@@ -375,7 +1171,7 @@ func runInteractiveMode() error {
 				path = "."
 			}
 
-			err := findDuplicateFiles(path)
+			err := findDuplicateFiles(path, duplicateOptions{})
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 			}
@@ -391,7 +1187,7 @@ func runInteractiveMode() error {
 				path = "."
 			}
 
-			err := analyzeDiskUsage(path)
+			err := analyzeDiskUsage(path, diskUsageOptions{})
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 			}
@@ -417,9 +1213,9 @@ func listDirectory(path string) error {
 	}
 
 	fmt.Printf("Contents of %s:\n", absPath)
-	fmt.Println(strings.Repeat("-", 80))
-	fmt.Printf("%-10s %-40s %-15s %s\n", "TYPE", "NAME", "SIZE", "MODIFIED")
-	fmt.Println(strings.Repeat("-", 80))
+	fmt.Println(strings.Repeat("-", 128))
+	fmt.Printf("%-10s %-40s %-15s %-20s %-20s %s\n", "TYPE", "NAME", "SIZE", "MODIFIED", "ACCESSED", "CREATED")
+	fmt.Println(strings.Repeat("-", 128))
 
 	for _, file := range files {
 		info, err := file.Info()
@@ -433,17 +1229,86 @@ func listDirectory(path string) error {
 		}
 
 		size := fmt.Sprintf("%d bytes", info.Size())
+		times := getFileTimes(filepath.Join(path, file.Name()), info)
 
-		fmt.Printf("%-10s %-40s %-15s %s\n",
+		fmt.Printf("%-10s %-40s %-15s %-20s %-20s %s\n",
 			fileType,
 			file.Name(),
 			size,
-			info.ModTime().Format("2006-01-02 15:04:05"),
+			times.Modified.Format("2006-01-02 15:04:05"),
+			formatOptionalTime(times.Accessed),
+			formatOptionalTime(times.Born),
 		)
 	}
 	return nil
 }
 
+// fileEntry is the JSON representation of a directory entry
+type fileEntry struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	Type     string     `json:"type"`
+	Size     int64      `json:"size"`
+	Mode     string     `json:"mode"`
+	Modified time.Time  `json:"modified"`
+	Accessed *time.Time `json:"accessed,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+}
+
+// newFileEntry builds the JSON representation of a file
+func newFileEntry(path string, info os.FileInfo) fileEntry {
+	entry := fileEntry{
+		Name: info.Name(),
+		Path: path,
+		Type: "file",
+		Size: info.Size(),
+		Mode: info.Mode().String(),
+	}
+	if info.IsDir() {
+		entry.Type = "dir"
+	}
+
+	times := getFileTimes(path, info)
+	entry.Modified = times.Modified
+	if !times.Accessed.IsZero() {
+		entry.Accessed = &times.Accessed
+	}
+	if !times.Born.IsZero() {
+		entry.Created = &times.Born
+	}
+	return entry
+}
+
+// listDirectoryJSON prints the directory contents as a JSON array
+func listDirectoryJSON(path string) error {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]fileEntry, 0, len(files))
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, newFileEntry(filepath.Join(absPath, file.Name()), info))
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
+
 func deleteFile(path string) error {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -472,12 +1337,87 @@ func deleteFile(path string) error {
 	return nil
 }
 
-func monitorDirectory(path string) error {
+// monitorOptions holds the optional behaviour of the monitor commands
+type monitorOptions struct {
+	Diff        bool
+	EventLog    string
+	EventDB     string
+	Exec        []string
+	HookWorkers int
+
+	// Attribute records which user and process made each change
+	Attribute bool
+}
+
+// monitorFlags returns the flags shared by monitor and monitor-all
+func monitorFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "diff",
+			Usage: "Print a unified diff when a small text file is modified",
+		},
+		&cli.StringFlag{
+			Name:  "event-log",
+			Usage: "Append events to `FILE` as JSON lines",
+		},
+		&cli.StringFlag{
+			Name:  "event-db",
+			Usage: "Store events in the SQLite database `FILE`",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exec",
+			Usage: "Run shell `COMMAND` for every event, in addition to the hooks in the config (can be repeated)",
+		},
+		&cli.IntFlag{
+			Name:  "hook-workers",
+			Value: defaultHookWorkers,
+			Usage: "Maximum number of hook commands running at once",
+		},
+		&cli.BoolFlag{
+			Name:  "attribute",
+			Usage: "Record which user and process made each change (Linux, needs root)",
+		},
+	}
+}
+
+// hookRules returns the hooks from the config plus those given with --exec
+func (o monitorOptions) hookRules() []HookRule {
+	rules := append([]HookRule{}, appConfig.Hooks...)
+	return append(rules, shellHooks(o.Exec)...)
+}
+
+// monitorOptionsFromContext reads the monitor flags of a command
+func monitorOptionsFromContext(c *cli.Context) monitorOptions {
+	return monitorOptions{
+		Diff:        c.Bool("diff"),
+		EventLog:    c.String("event-log"),
+		EventDB:     c.String("event-db"),
+		Exec:        c.StringSlice("exec"),
+		HookWorkers: c.Int("hook-workers"),
+		Attribute:   c.Bool("attribute"),
+	}
+}
+
+func monitorDirectory(path string, opts monitorOptions) error {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return err
 	}
 
+	recorder, err := newEventRecorder(opts)
+	if err != nil {
+		return err
+	}
+	defer recorder.Close()
+	stopPruning := startPruneJob(recorder)
+	defer stopPruning()
+
+	hooks, err := newHookRunner(opts.hookRules(), opts.HookWorkers)
+	if err != nil {
+		return err
+	}
+	defer hooks.Close()
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
@@ -494,8 +1434,28 @@ func monitorDirectory(path string) error {
 	fmt.Println("\nStarting monitoring... (Press Ctrl+C to stop)")
 	fmt.Println(strings.Repeat("-", 80))
 
+	ignore := newIgnoreMatcher(absPath)
+	attrs := newAttrCache()
+	attrs.Prime(absPath)
+	quotas := newQuotaTracker([]string{absPath})
+	defer quotas.Close()
+
+	attribution, stopAttribution, err := newAttribution([]string{absPath}, opts)
+	if err != nil {
+		return err
+	}
+	defer stopAttribution()
+
+	var texts *textCache
+	if opts.Diff {
+		texts = newTextCache()
+		texts.Prime(absPath)
+	}
+
 	// Start listening for events
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for {
 			select {
 			case event, ok := <-watcher.Events:
@@ -503,25 +1463,33 @@ func monitorDirectory(path string) error {
 					return
 				}
 
-				eventType := ""
-				switch {
-				case event.Op&fsnotify.Create == fsnotify.Create:
-					eventType = "CREATED"
-				case event.Op&fsnotify.Write == fsnotify.Write:
-					eventType = "MODIFIED"
-				case event.Op&fsnotify.Remove == fsnotify.Remove:
-					eventType = "DELETED"
-				case event.Op&fsnotify.Rename == fsnotify.Rename:
-					eventType = "RENAMED"
-				case event.Op&fsnotify.Chmod == fsnotify.Chmod:
-					eventType = "CHMOD"
+				if recorder.IsOwnFile(event.Name) || isIgnoredEvent(ignore, event) {
+					continue
 				}
 
-				fmt.Printf("[%s] %s - %s\n",
-					time.Now().Format("15:04:05"),
-					eventType,
-					filepath.Base(event.Name),
-				)
+				now := time.Now()
+				eventType := eventTypeName(event.Op)
+				detail, size := attrs.Observe(event)
+				recorded := Event{Time: now, Root: absPath, Path: event.Name, Op: eventType, Detail: detail, Size: size}
+				attribution.Deliver(recorded, func(recorded Event) {
+					recorder.Record(recorded)
+					hooks.Dispatch(recorded)
+					if detail != "" {
+						eventType += " (" + detail + ")"
+					}
+
+					fmt.Printf("[%s] %s - %s%s\n",
+						now.Format("15:04:05"),
+						eventType,
+						filepath.Base(event.Name),
+						describeAttribution(recorded),
+					)
+
+					if texts != nil {
+						fmt.Print(texts.Observe(event))
+					}
+					quotas.Observe(recorded)
+				})
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -531,17 +1499,45 @@ func monitorDirectory(path string) error {
 		}
 	}()
 
+	// Stop the event loop before the deferred calls close the hooks and
+	// event sinks it sends to
+	defer func() {
+		watcher.Close()
+		<-done
+	}()
+
 	// Add a path to watch
 	err = watcher.Add(absPath)
 	if err != nil {
 		return err
 	}
 
-	// Wait forever
-	<-make(chan struct{})
+	// Wait until interrupted, so that sinks are closed and telemetry is
+	// flushed on the way out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	fmt.Println("\nStopping monitoring")
 	return nil
 }
 
+// eventTypeName returns the label printed for a watcher event
+func eventTypeName(op fsnotify.Op) string {
+	switch {
+	case op&fsnotify.Create == fsnotify.Create:
+		return "CREATED"
+	case op&fsnotify.Write == fsnotify.Write:
+		return "MODIFIED"
+	case op&fsnotify.Remove == fsnotify.Remove:
+		return "DELETED"
+	case op&fsnotify.Rename == fsnotify.Rename:
+		return "RENAMED"
+	case op&fsnotify.Chmod == fsnotify.Chmod:
+		return "CHMOD"
+	}
+	return ""
+}
+
 // provideCleanupAdvice analyzes files in a directory and recommends which ones to delete
 func provideCleanupAdvice(path string, ageThreshold, sizeThreshold int) error {
 	files, err := os.ReadDir(path)
@@ -561,16 +1557,22 @@ func provideCleanupAdvice(path string, ageThreshold, sizeThreshold int) error {
 
 	var totalPotentialSavings int64
 	var recommendedFiles []string
+	rules := make(map[string]string)
 
 	now := time.Now()
 	ageThresholdDuration := time.Duration(ageThreshold*24) * time.Hour
 	sizeThresholdBytes := int64(sizeThreshold * 1024 * 1024)
+	ignore := newIgnoreMatcher(path)
 
 	for _, file := range files {
 		if file.IsDir() {
 			continue // Skip directories for now
 		}
 
+		if ignore.Match(filepath.Join(path, file.Name()), false) {
+			continue
+		}
+
 		info, err := file.Info()
 		if err != nil {
 			continue
@@ -578,17 +1580,19 @@ func provideCleanupAdvice(path string, ageThreshold, sizeThreshold int) error {
 
 		filePath := filepath.Join(path, file.Name())
 		fileAge := now.Sub(info.ModTime())
-		reason := ""
+		reason, rule := "", ""
 
 		// Check for temporary or log files
 		if isTempFile(file.Name()) {
-			reason = "Temporary file"
+			reason, rule = "Temporary file", "temporary files"
 		} else if isLogFile(file.Name()) {
-			reason = "Log file"
+			reason, rule = "Log file", "log files"
 		} else if fileAge > ageThresholdDuration && info.Size() > 0 {
 			reason = fmt.Sprintf("Not modified for %d days", int(fileAge.Hours()/24))
+			rule = "old files"
 		} else if info.Size() > sizeThresholdBytes {
 			reason = fmt.Sprintf("Large file (%s)", formatSize(info.Size()))
+			rule = "large files"
 		}
 
 		if reason != "" {
@@ -600,6 +1604,7 @@ func provideCleanupAdvice(path string, ageThreshold, sizeThreshold int) error {
 
 			totalPotentialSavings += info.Size()
 			recommendedFiles = append(recommendedFiles, filePath)
+			rules[filePath] = rule
 		}
 	}
 
@@ -616,223 +1621,25 @@ func provideCleanupAdvice(path string, ageThreshold, sizeThreshold int) error {
 	fmt.Scanln(&response)
 
 	if strings.ToLower(response) == "y" || strings.ToLower(response) == "yes" {
+		savings := newSavingsRecorder("cleanup")
 		for _, filePath := range recommendedFiles {
-			if err := os.Remove(filePath); err != nil {
+			info, err := os.Lstat(filePath)
+			if err == nil {
+				err = os.Remove(filePath)
+			}
+			if err != nil {
 				fmt.Printf("Error deleting %s: %v\n", filePath, err)
 			} else {
 				fmt.Printf("Deleted: %s\n", filePath)
+				savings.Add(absPath, rules[filePath], info.Size())
 			}
 		}
+		savings.Save()
 	}
 
 	return nil
 }
 
-// findDuplicateFiles identifies potential duplicate files in a directory
-func findDuplicateFiles(path string) error {
-	// First pass: get file sizes and organize by size
-	filesBySize := make(map[int64][]string)
-
-	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() {
-			filesBySize[info.Size()] = append(filesBySize[info.Size()], filePath)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return err
-	}
-
-	// Second pass: compute MD5 hashes for potential duplicates (files with same size)
-	duplicateGroups := make(map[string][]string)
-
-	for size, files := range filesBySize {
-		if len(files) > 1 && size > 0 {
-			// Files with the same size are potential duplicates
-			for _, file := range files {
-				hash, err := calculateMD5(file)
-				if err != nil {
-					fmt.Printf("Error calculating hash for %s: %v\n", file, err)
-					continue
-				}
-
-				duplicateGroups[hash] = append(duplicateGroups[hash], file)
-			}
-		}
-	}
-
-	// Display results
-	duplicateCount := 0
-	var totalWasted int64
-
-	fmt.Println("Duplicate files:")
-	fmt.Println(strings.Repeat("-", 80))
-
-	for hash, files := range duplicateGroups {
-		if len(files) > 1 {
-			duplicateCount++
-
-			// Get file size (all files in this group have the same size)
-			info, err := os.Stat(files[0])
-			if err != nil {
-				continue
-			}
-
-			// Calculate wasted space
-			wastedSpace := info.Size() * int64(len(files)-1)
-			totalWasted += wastedSpace
-
-			fmt.Printf("\nDuplicate Group %d (%s, wasted: %s):\n",
-				duplicateCount, hash[:8], formatSize(wastedSpace))
-
-			for i, file := range files {
-				fmt.Printf("%d. %s\n", i+1, file)
-			}
-		}
-	}
-
-	if duplicateCount == 0 {
-		fmt.Println("No duplicate files found.")
-		return nil
-	}
-
-	fmt.Println(strings.Repeat("-", 80))
-	fmt.Printf("Found %d groups of duplicate files\n", duplicateCount)
-	fmt.Printf("Potential space savings: %s\n", formatSize(totalWasted))
-
-	return nil
-}
-
-// analyzeDiskUsage shows disk usage by file types and directories
-func analyzeDiskUsage(path string) error {
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return err
-	}
-
-	// Collect stats by file type and directory
-	typeStats := make(map[string]int64)
-	dirStats := make(map[string]int64)
-
-	var totalSize int64
-
-	err = filepath.Walk(absPath, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files we can't access
-		}
-
-		// Skip the root directory itself
-		if filePath == absPath {
-			return nil
-		}
-
-		if !info.IsDir() {
-			// Update total size
-			totalSize += info.Size()
-
-			// Update file type stats
-			ext := strings.ToLower(filepath.Ext(filePath))
-			if ext == "" {
-				ext = "[no extension]"
-			}
-			typeStats[ext] += info.Size()
-
-			// Update directory stats (by parent directory)
-			parentDir := filepath.Dir(filePath)
-			dirStats[parentDir] += info.Size()
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return err
-	}
-
-	// Display results by file type
-	fmt.Printf("Disk usage analysis for: %s\n\n", absPath)
-	fmt.Println("Usage by file type:")
-	fmt.Println(strings.Repeat("-", 60))
-	fmt.Printf("%-20s %-15s %s\n", "FILE TYPE", "SIZE", "% OF TOTAL")
-	fmt.Println(strings.Repeat("-", 60))
-
-	// Convert to slice for sorting
-	type typeStat struct {
-		ext  string
-		size int64
-	}
-
-	typeStatsList := make([]typeStat, 0, len(typeStats))
-	for ext, size := range typeStats {
-		typeStatsList = append(typeStatsList, typeStat{ext, size})
-	}
-
-	// Sort by size (descending)
-	sort.Slice(typeStatsList, func(i, j int) bool {
-		return typeStatsList[i].size > typeStatsList[j].size
-	})
-
-	for _, stat := range typeStatsList {
-		percentage := float64(stat.size) / float64(totalSize) * 100
-		fmt.Printf("%-20s %-15s %.1f%%\n",
-			stat.ext, formatSize(stat.size), percentage)
-	}
-
-	// Display results by directory
-	fmt.Println("\nLargest directories:")
-	fmt.Println(strings.Repeat("-", 70))
-	fmt.Printf("%-50s %-15s\n", "DIRECTORY", "SIZE")
-	fmt.Println(strings.Repeat("-", 70))
-
-	// Convert to slice for sorting
-	type dirStat struct {
-		dir  string
-		size int64
-	}
-
-	dirStatsList := make([]dirStat, 0, len(dirStats))
-	for dir, size := range dirStats {
-		dirStatsList = append(dirStatsList, dirStat{dir, size})
-	}
-
-	// Sort by size (descending)
-	sort.Slice(dirStatsList, func(i, j int) bool {
-		return dirStatsList[i].size > dirStatsList[j].size
-	})
-
-	// Show top 10 directories
-	count := 0
-	for _, stat := range dirStatsList {
-		relPath, err := filepath.Rel(absPath, stat.dir)
-		if err != nil {
-			relPath = stat.dir
-		}
-
-		if relPath == "." {
-			relPath = "[root directory]"
-		}
-
-		fmt.Printf("%-50s %-15s\n",
-			truncateString(relPath, 49), formatSize(stat.size))
-
-		count++
-		if count >= 10 {
-			break
-		}
-	}
-
-	fmt.Println(strings.Repeat("-", 70))
-	fmt.Printf("Total size: %s\n", formatSize(totalSize))
-
-	return nil
-}
-
 // Helper functions
 func isTempFile(filename string) bool {
 	lowerName := strings.ToLower(filename)
@@ -872,6 +1679,11 @@ func truncateString(s string, maxLen int) string {
 	return s[:maxLen-3] + "..."
 }
 
+// isAncestorPath reports whether dir is a parent directory of path
+func isAncestorPath(dir, path string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
 func calculateMD5(filePath string) (string, error) {
 	// Open the file
 	file, err := os.Open(filePath)
@@ -896,37 +1708,48 @@ func calculateMD5(filePath string) (string, error) {
 
 	return hashString, nil
 }
-func addDirectory(path string) error {
+
+// addMonitoredDir adds a directory to the monitored list and saves the
+// config, returning its absolute path and whether it was newly added
+func addMonitoredDir(path string) (string, bool, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return err
+		return "", false, err
 	}
 
 	// Verify it's a directory
 	info, err := os.Stat(absPath)
 	if err != nil {
-		return err
+		return "", false, err
 	}
 
 	if !info.IsDir() {
-		return fmt.Errorf("%s is not a directory", absPath)
+		return "", false, fmt.Errorf("%s is not a directory", absPath)
 	}
 
 	// Check if already in the list
 	for _, dir := range appConfig.MonitoredDirs {
 		if dir == absPath {
-			fmt.Printf("Directory %s is already in the monitored list\n", absPath)
-			return nil
+			return absPath, false, nil
 		}
 	}
 
 	// Add to the list
 	appConfig.MonitoredDirs = append(appConfig.MonitoredDirs, absPath)
-	err = saveConfig()
+	return absPath, true, saveConfig()
+}
+
+func addDirectory(path string) error {
+	absPath, added, err := addMonitoredDir(path)
 	if err != nil {
 		return err
 	}
 
+	if !added {
+		fmt.Printf("Directory %s is already in the monitored list\n", absPath)
+		return nil
+	}
+
 	fmt.Printf("Directory %s has been added to the monitored list\n", absPath)
 	return nil
 }
@@ -970,11 +1793,37 @@ func removeDirectory() error {
 	return nil
 }
 
-func monitorAllDirectories() error {
+// removeMonitoredDir removes a directory from the monitored list and saves
+// the config, reporting whether it was in the list
+func removeMonitoredDir(path string) (bool, error) {
+	for i, dir := range appConfig.MonitoredDirs {
+		if dir == path {
+			appConfig.MonitoredDirs = append(appConfig.MonitoredDirs[:i], appConfig.MonitoredDirs[i+1:]...)
+			return true, saveConfig()
+		}
+	}
+	return false, nil
+}
+
+func monitorAllDirectories(opts monitorOptions) error {
 	if len(appConfig.MonitoredDirs) == 0 {
 		return fmt.Errorf("no directories to monitor")
 	}
 
+	recorder, err := newEventRecorder(opts)
+	if err != nil {
+		return err
+	}
+	defer recorder.Close()
+	stopPruning := startPruneJob(recorder)
+	defer stopPruning()
+
+	hooks, err := newHookRunner(opts.hookRules(), opts.HookWorkers)
+	if err != nil {
+		return err
+	}
+	defer hooks.Close()
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
@@ -982,7 +1831,18 @@ func monitorAllDirectories() error {
 	defer watcher.Close()
 
 	// Add all paths to watch
+	ignores := make(map[string]*ignoreMatcher)
+	attrs := newAttrCache()
+	var texts *textCache
+	if opts.Diff {
+		texts = newTextCache()
+	}
 	for _, dir := range appConfig.MonitoredDirs {
+		ignores[dir] = newIgnoreMatcher(dir)
+		attrs.Prime(dir)
+		if texts != nil {
+			texts.Prime(dir)
+		}
 		fmt.Printf("Adding %s to watch list\n", dir)
 		err = watcher.Add(dir)
 		if err != nil {
@@ -990,11 +1850,30 @@ func monitorAllDirectories() error {
 		}
 	}
 
+	// Overlapping roots deliver some events once per watch, so warn about
+	// them and attribute each event to the most specific root only once
+	roots := newWatchRoots(appConfig.MonitoredDirs)
+	for _, overlap := range roots.Overlaps() {
+		fmt.Printf("Warning: %s overlaps with %s, events will be reported once under the most specific directory\n",
+			overlap.inner, overlap.outer)
+	}
+	deduper := newEventDeduper(roots)
+	quotas := newQuotaTracker(appConfig.MonitoredDirs)
+	defer quotas.Close()
+
+	attribution, stopAttribution, err := newAttribution(appConfig.MonitoredDirs, opts)
+	if err != nil {
+		return err
+	}
+	defer stopAttribution()
+
 	fmt.Println("\nStarting monitoring of all directories... (Press Ctrl+C to stop)")
 	fmt.Println(strings.Repeat("-", 80))
 
 	// Start listening for events
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for {
 			select {
 			case event, ok := <-watcher.Events:
@@ -1002,29 +1881,44 @@ func monitorAllDirectories() error {
 					return
 				}
 
-				eventType := ""
-				switch {
-				case event.Op&fsnotify.Create == fsnotify.Create:
-					eventType = "CREATED"
-				case event.Op&fsnotify.Write == fsnotify.Write:
-					eventType = "MODIFIED"
-				case event.Op&fsnotify.Remove == fsnotify.Remove:
-					eventType = "DELETED"
-				case event.Op&fsnotify.Rename == fsnotify.Rename:
-					eventType = "RENAMED"
-				case event.Op&fsnotify.Chmod == fsnotify.Chmod:
-					eventType = "CHMOD"
+				if recorder.IsOwnFile(event.Name) || deduper.Duplicate(event) {
+					continue
 				}
 
-				// Get directory path for the event
-				dirPath := filepath.Dir(event.Name)
+				// Get the monitored directory the event belongs to
+				root := roots.Attribute(event.Name)
+				if root == "" {
+					root = filepath.Dir(event.Name)
+				}
 
-				fmt.Printf("[%s] [%s] %s - %s\n",
-					time.Now().Format("15:04:05"),
-					dirPath,
-					eventType,
-					filepath.Base(event.Name),
-				)
+				if ignore, ok := ignores[root]; ok && isIgnoredEvent(ignore, event) {
+					continue
+				}
+
+				now := time.Now()
+				eventType := eventTypeName(event.Op)
+				detail, size := attrs.Observe(event)
+				recorded := Event{Time: now, Root: root, Path: event.Name, Op: eventType, Detail: detail, Size: size}
+				attribution.Deliver(recorded, func(recorded Event) {
+					recorder.Record(recorded)
+					hooks.Dispatch(recorded)
+					if detail != "" {
+						eventType += " (" + detail + ")"
+					}
+
+					fmt.Printf("[%s] [%s] %s - %s%s\n",
+						now.Format("15:04:05"),
+						root,
+						eventType,
+						filepath.Base(event.Name),
+						describeAttribution(recorded),
+					)
+
+					if texts != nil {
+						fmt.Print(texts.Observe(event))
+					}
+					quotas.Observe(recorded)
+				})
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -1034,7 +1928,18 @@ func monitorAllDirectories() error {
 		}
 	}()
 
-	// Wait forever
-	<-make(chan struct{})
+	// Stop the event loop before the deferred calls close the hooks and
+	// event sinks it sends to
+	defer func() {
+		watcher.Close()
+		<-done
+	}()
+
+	// Wait until interrupted, so that sinks are closed and telemetry is
+	// flushed on the way out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	fmt.Println("\nStopping monitoring")
 	return nil
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// addMasterDir marks a directory as a master: duplicate actions never delete
+// files inside it
+func addMasterDir(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", absPath)
+	}
+
+	for _, dir := range appConfig.MasterDirs {
+		if dir == absPath {
+			fmt.Printf("Directory %s is already a master directory\n", absPath)
+			return nil
+		}
+		if isAncestorPath(dir, absPath) {
+			fmt.Printf("Directory %s is already protected by master directory %s\n", absPath, dir)
+			return nil
+		}
+	}
+
+	appConfig.MasterDirs = append(appConfig.MasterDirs, absPath)
+	if err := saveConfig(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Directory %s is now a master directory\n", absPath)
+	return nil
+}
+
+// removeMasterDir removes a directory from the master directories
+func removeMasterDir(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	for i, dir := range appConfig.MasterDirs {
+		if dir == absPath {
+			appConfig.MasterDirs = append(appConfig.MasterDirs[:i], appConfig.MasterDirs[i+1:]...)
+			if err := saveConfig(); err != nil {
+				return err
+			}
+			fmt.Printf("Directory %s is no longer a master directory\n", absPath)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a master directory", absPath)
+}
+
+// viewMasterDirs lists the master directories
+func viewMasterDirs() {
+	if len(appConfig.MasterDirs) == 0 {
+		fmt.Println("No master directories configured")
+		return
+	}
+
+	fmt.Println("Master directories (duplicates inside them are never deleted):")
+	fmt.Println(strings.Repeat("-", 80))
+	for i, dir := range appConfig.MasterDirs {
+		fmt.Printf("%d. %s\n", i+1, dir)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// copiedFile is a source file copied by copyTree, as it was before copying
+type copiedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// changed reports whether the file was modified or removed since it was copied
+func (f copiedFile) changed() bool {
+	info, err := os.Lstat(f.path)
+	return err != nil || info.Size() != f.size || !info.ModTime().Equal(f.modTime)
+}
+
+// copyTree copies a file, symlink or directory tree to dst with the copy
+// engine, returning the regular files it copied. created reports whether
+// dst itself was created, so that a failed copy can be cleaned up without
+// touching anything that was there before.
+func copyTree(src, dst string, opts copyOptions) (copied []copiedFile, created bool, err error) {
+	var copyEntry func(src, dst string) error
+	copyEntry = func(src, dst string) error {
+		info, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode().IsRegular():
+			if err := copyFile(src, dst, opts); err != nil {
+				return err
+			}
+			copied = append(copied, copiedFile{path: src, size: info.Size(), modTime: info.ModTime()})
+
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, dst); err != nil {
+				return err
+			}
+
+		case info.IsDir():
+			// Stay writable until the contents are copied
+			if err := os.Mkdir(dst, info.Mode().Perm()|0700); err != nil {
+				return err
+			}
+			created = true
+
+			entries, err := os.ReadDir(src)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if err := copyEntry(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+					return err
+				}
+			}
+
+			if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+				return err
+			}
+			if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%s is a special file and can't be copied", src)
+		}
+
+		created = true
+		return nil
+	}
+
+	err = copyEntry(src, dst)
+	return copied, created, err
+}
+
+// moveFile moves a file, symlink or directory to dst, which must not exist
+// yet, creating missing directories. Within a filesystem it is renamed.
+// Across filesystems it is copied and verified with the copy engine, and
+// the source is deleted afterwards.
+//
+// If the copy fails, or the source changes while it is being copied,
+// everything created at dst is removed again and the source is left as it
+// was. If a single file can't be deleted after copying, its copy is removed
+// so the file isn't left in two places; for a directory whose deletion
+// fails halfway, the complete copy is kept, as parts of the source are gone.
+func moveFile(src, dst string, opts copyOptions) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s: %w", dst, os.ErrExist)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	copied, created, err := copyTree(src, dst, opts)
+	if err == nil {
+		for _, file := range copied {
+			if file.changed() {
+				err = fmt.Errorf("%s changed while it was being copied", file.path)
+				break
+			}
+		}
+	}
+	if err != nil {
+		if created {
+			os.RemoveAll(dst)
+		}
+		return fmt.Errorf("moving %s to another filesystem: %w", src, err)
+	}
+
+	if !info.IsDir() {
+		if err := os.Remove(src); err != nil {
+			if cleanupErr := os.Remove(dst); cleanupErr != nil {
+				return fmt.Errorf("copied %s to %s but could not delete the original (%v) or the copy (%v)", src, dst, err, cleanupErr)
+			}
+			return fmt.Errorf("moving %s to another filesystem: could not delete the original: %w", src, err)
+		}
+		return nil
+	}
+
+	if err := os.RemoveAll(src); err != nil {
+		return fmt.Errorf("copied %s to %s but could only partly delete the original: %w", src, dst, err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+// isCrossDevice reports whether a rename failed because source and
+// destination are on different filesystems; this platform doesn't say
+func isCrossDevice(err error) bool {
+	return false
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Kinds of filename problems
+const (
+	nameInvalidUTF8  = "invalid UTF-8"
+	nameReserved     = "reserved on Windows"
+	nameInvalidChar  = "invalid on Windows"
+	nameTrailing     = "trailing space or dot"
+	nameNotNFC       = "not NFC normalized"
+	nameNormConflict = "normalization conflict"
+	nameCaseConflict = "case conflict"
+	nameTooLong      = "name too long"
+	namePathTooLong  = "path too long"
+)
+
+const (
+	// defaultMaxPathLength is the default limit for paths relative to the
+	// checked directory: Windows' MAX_PATH of 260 characters, less room for
+	// the folder the tree is copied to
+	defaultMaxPathLength = 200
+
+	// maxNameLength is the longest file name NTFS, APFS and ext4 all allow,
+	// in UTF-16 code units
+	maxNameLength = 255
+)
+
+// nameOptions holds the optional behaviour of the names and portability
+// commands
+type nameOptions struct {
+	Fix     bool
+	Yes     bool
+	MaxPath int
+
+	// Strict makes any problem an error, for gating scripts
+	Strict bool
+}
+
+// utf16Length returns the length of a string as Windows counts it
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// windowsReservedNames can't be used as a file name on Windows, with or
+// without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// nameIssue is a problem with one file name
+type nameIssue struct {
+	Path   string
+	Kind   string
+	Detail string
+
+	// Fix is the suggested new name, empty if the problem must be fixed by
+	// hand (e.g. two names that only differ by case)
+	Fix string
+}
+
+// windowsReservedBase reports whether a name is reserved on Windows, which
+// ignores everything from the first dot as well as trailing spaces
+func windowsReservedBase(name string) bool {
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	return windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))]
+}
+
+// windowsInvalidChar returns the first character of a name that Windows
+// doesn't allow, or -1
+func windowsInvalidChar(name string) rune {
+	for _, r := range name {
+		if r < 32 || strings.ContainsRune(`<>:"|?*\`, r) {
+			return r
+		}
+	}
+	return -1
+}
+
+// portableName returns a name with the fixable problems removed: invalid
+// UTF-8 and characters Windows doesn't allow become "_", the name is NFC
+// normalized, trailing spaces and dots are dropped, and reserved names get
+// a "_" appended to their base
+func portableName(name string) string {
+	fixed := norm.NFC.String(strings.ToValidUTF8(name, "_"))
+	fixed = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"|?*\`, r) {
+			return '_'
+		}
+		return r
+	}, fixed)
+	fixed = strings.TrimRight(fixed, " .")
+
+	if windowsReservedBase(fixed) {
+		if i := strings.IndexByte(fixed, '.'); i >= 0 {
+			fixed = fixed[:i] + "_" + fixed[i:]
+		} else {
+			fixed += "_"
+		}
+	}
+	if fixed == "" {
+		fixed = "_"
+	}
+	return fixed
+}
+
+// checkName returns the problems of a single name, independent of its
+// siblings
+func checkName(dir, name string) []nameIssue {
+	path := filepath.Join(dir, name)
+	fix := portableName(name)
+
+	var issues []nameIssue
+	add := func(kind, detail string) {
+		issues = append(issues, nameIssue{Path: path, Kind: kind, Detail: detail, Fix: fix})
+	}
+
+	if !utf8.ValidString(name) {
+		add(nameInvalidUTF8, "")
+	} else if !norm.NFC.IsNormalString(name) {
+		add(nameNotNFC, "decomposed (NFD) names look identical but differ between macOS and Linux")
+	}
+	if windowsReservedBase(name) {
+		add(nameReserved, "")
+	}
+	if r := windowsInvalidChar(name); r >= 0 {
+		add(nameInvalidChar, fmt.Sprintf("contains %q", r))
+	}
+	if strings.HasSuffix(name, " ") || strings.HasSuffix(name, ".") {
+		add(nameTrailing, "")
+	}
+	if length := utf16Length(name); length > maxNameLength {
+		issues = append(issues, nameIssue{
+			Path:   path,
+			Kind:   nameTooLong,
+			Detail: fmt.Sprintf("%d characters, limit %d", length, maxNameLength),
+		})
+	}
+	return issues
+}
+
+// checkSiblingNames finds names in one directory that different systems
+// would treat as the same file: equal after Unicode normalization, or equal
+// ignoring case
+func checkSiblingNames(dir string, names []string) []nameIssue {
+	var issues []nameIssue
+	byNFC := make(map[string]string)
+	byFold := make(map[string]string)
+
+	for _, name := range names {
+		nfc := norm.NFC.String(name)
+		if other, ok := byNFC[nfc]; ok {
+			issues = append(issues, nameIssue{
+				Path:   filepath.Join(dir, name),
+				Kind:   nameNormConflict,
+				Detail: "same name as " + other,
+			})
+			continue
+		}
+		byNFC[nfc] = name
+
+		folded := strings.ToLower(nfc)
+		if other, ok := byFold[folded]; ok {
+			issues = append(issues, nameIssue{
+				Path:   filepath.Join(dir, name),
+				Kind:   nameCaseConflict,
+				Detail: "same name as " + other,
+			})
+			continue
+		}
+		byFold[folded] = name
+	}
+	return issues
+}
+
+// scanNames walks a tree and collects filename problems. Besides the
+// problems of each name and its siblings, it finds paths longer than maxPath
+// and paths that collide once directories differing only by case are
+// merged, as happens when the tree is copied to Windows or macOS.
+func scanNames(absPath string, maxPath int) ([]nameIssue, error) {
+	ignore := newIgnoreMatcher(absPath)
+	byFoldedPath := make(map[string]string)
+
+	var issues []nameIssue
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		var names []string
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if ignore.Match(path, entry.IsDir()) {
+				continue
+			}
+
+			names = append(names, entry.Name())
+			issues = append(issues, checkName(dir, entry.Name())...)
+
+			rel, _ := filepath.Rel(absPath, path)
+			if length := utf16Length(rel); maxPath > 0 && length > maxPath {
+				issues = append(issues, nameIssue{
+					Path:   path,
+					Kind:   namePathTooLong,
+					Detail: fmt.Sprintf("%d characters, limit %d", length, maxPath),
+				})
+			}
+
+			// Collisions between siblings are reported by checkSiblingNames
+			folded := strings.ToLower(norm.NFC.String(rel))
+			if other, ok := byFoldedPath[folded]; ok && filepath.Dir(other) != filepath.Dir(rel) {
+				issues = append(issues, nameIssue{
+					Path:   path,
+					Kind:   nameCaseConflict,
+					Detail: "same path as " + other + " on case-insensitive filesystems",
+				})
+			} else if !ok {
+				byFoldedPath[folded] = rel
+			}
+
+			if entry.IsDir() {
+				if err := walk(path); err != nil {
+					fmt.Printf("Error reading %s: %v\n", path, err)
+				}
+			}
+		}
+
+		issues = append(issues, checkSiblingNames(dir, names)...)
+		return nil
+	}
+
+	if err := walk(absPath); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Path < issues[j].Path
+	})
+	return issues, nil
+}
+
+// displayPath quotes paths that aren't printable as they are
+func displayPath(path string) string {
+	if !utf8.ValidString(path) || strings.IndexFunc(path, func(r rune) bool { return r < 32 }) >= 0 {
+		return strconv.Quote(path)
+	}
+	return path
+}
+
+// fixNames renames files to their portable names. Renames are skipped when
+// the new name is taken, or when another file in the directory would get
+// the same name.
+func fixNames(issues []nameIssue) {
+	renames := make(map[string]string)
+	targets := make(map[string]int)
+	for _, issue := range issues {
+		if issue.Fix == "" {
+			continue
+		}
+		if _, ok := renames[issue.Path]; ok {
+			continue
+		}
+		dest := filepath.Join(filepath.Dir(issue.Path), issue.Fix)
+		renames[issue.Path] = dest
+		targets[strings.ToLower(dest)]++
+	}
+
+	// Rename the deepest paths first, so renamed directories don't change
+	// the paths of files still to be renamed
+	paths := make([]string, 0, len(renames))
+	for path := range renames {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return len(paths[i]) > len(paths[j])
+	})
+
+	renamed, skipped := 0, 0
+	for _, path := range paths {
+		dest := renames[path]
+		if targets[strings.ToLower(dest)] > 1 {
+			fmt.Printf("Skipping %s: another file would also be renamed to %s\n", displayPath(path), filepath.Base(dest))
+			skipped++
+			continue
+		}
+		if _, err := os.Lstat(dest); err == nil {
+			fmt.Printf("Skipping %s: %s already exists\n", displayPath(path), filepath.Base(dest))
+			skipped++
+			continue
+		}
+		if err := os.Rename(path, dest); err != nil {
+			fmt.Printf("Error renaming %s: %v\n", displayPath(path), err)
+			skipped++
+			continue
+		}
+		fmt.Printf("Renamed %s -> %s\n", displayPath(path), filepath.Base(dest))
+		renamed++
+	}
+
+	fmt.Printf("Renamed %d files", renamed)
+	if skipped > 0 {
+		fmt.Printf(" (%d skipped)", skipped)
+	}
+	fmt.Println()
+}
+
+// checkFileNames reports filenames in a tree that cause problems on other
+// platforms or filesystems, and optionally renames them
+func checkFileNames(path string, opts nameOptions) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	issues, err := scanNames(absPath, opts.MaxPath)
+	if err != nil {
+		return err
+	}
+
+	if len(issues) == 0 {
+		fmt.Printf("No problematic file names found in %s\n", absPath)
+		return nil
+	}
+
+	fmt.Printf("Problematic file names in %s:\n", absPath)
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("%-24s %s\n", "ISSUE", "PATH")
+	fmt.Println(strings.Repeat("-", 100))
+
+	fixable := 0
+	for _, issue := range issues {
+		rel, err := filepath.Rel(absPath, issue.Path)
+		if err != nil {
+			rel = issue.Path
+		}
+
+		fmt.Printf("%-24s %s\n", issue.Kind, displayPath(rel))
+		if issue.Detail != "" {
+			fmt.Printf("%-24s   %s\n", "", issue.Detail)
+		}
+		if issue.Fix != "" {
+			fmt.Printf("%-24s   suggested name: %s\n", "", issue.Fix)
+			fixable++
+		}
+	}
+
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("Found %d problems\n", len(issues))
+	if opts.Strict {
+		return fmt.Errorf("%s is not portable, found %d problems", absPath, len(issues))
+	}
+	if !opts.Fix {
+		if fixable > 0 {
+			fmt.Println("Run with --fix to rename files to the suggested names. Conflicts must be resolved by hand.")
+		}
+		return nil
+	}
+
+	if fixable == 0 {
+		fmt.Println("Nothing can be fixed automatically, conflicts must be resolved by hand")
+		return nil
+	}
+
+	if !opts.Yes {
+		fmt.Print("\nWould you like to rename these files to the suggested names? (y/N): ")
+		var response string
+		fmt.Scanln(&response)
+
+		if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+			fmt.Println("Operation cancelled")
+			return nil
+		}
+	}
+
+	fixNames(issues)
+	return nil
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// packOptions holds the optional behaviour of the pack command
+type packOptions struct {
+	OlderThan string
+	Output    string
+	DryRun    bool
+	Yes       bool
+
+	// BytesPerSecond limits how fast files are read; 0 means no limit
+	BytesPerSecond int64
+
+	// Keep leaves the original files in place after packing
+	Keep bool
+}
+
+// packManifest describes a pack archive. It is kept in the state store so
+// that files can be found and extracted by their original path.
+type packManifest struct {
+	ID        string       `json:"id"`
+	Created   time.Time    `json:"created"`
+	Root      string       `json:"root"`
+	Archive   string       `json:"archive"`
+	OlderThan string       `json:"older_than"`
+	Files     []packedFile `json:"files"`
+}
+
+// packedFile is a file in a pack archive. Each file is stored as a tar entry
+// in its own zstd frame, starting at Offset and Length bytes long, so it can
+// be extracted without decompressing the rest of the archive. The archive as
+// a whole is still a regular tar.zst.
+type packedFile struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	SHA256  string      `json:"sha256"`
+	Offset  int64       `json:"offset"`
+	Length  int64       `json:"length"`
+}
+
+// originalPath returns where a packed file was packed from
+func (m *packManifest) originalPath(file packedFile) string {
+	return filepath.Join(m.Root, file.Path)
+}
+
+// parseAge parses an age such as "90d", "2w", "6m" or "1y", or a Go duration
+// like "720h". Months count as 30 days and years as 365.
+func parseAge(age string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'm': 30 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+
+	if len(age) > 1 {
+		if unit, ok := units[age[len(age)-1]]; ok {
+			n, err := strconv.Atoi(age[:len(age)-1])
+			if err == nil && n >= 0 {
+				return time.Duration(n) * unit, nil
+			}
+		}
+	}
+
+	duration, err := time.ParseDuration(age)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid age %q, use e.g. 90d, 6m or 1y", age)
+	}
+	return duration, nil
+}
+
+// packsDir returns the directory holding the archives by default, which
+// with the file store also holds their manifests
+func packsDir() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "packs")
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// coldFile is a file selected for packing
+type coldFile struct {
+	path string
+	info os.FileInfo
+}
+
+// findColdFiles returns the regular files below root not modified since
+// cutoff, skipping ignored paths and the directory archives are written to
+func findColdFiles(root, output string, cutoff time.Time) ([]coldFile, error) {
+	ignore := newIgnoreMatcher(root)
+
+	var files []coldFile
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // Skip files we can't access
+		}
+		if path == root {
+			return nil
+		}
+
+		if ignore.Match(path, info.IsDir()) || (info.IsDir() && path == output) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsRegular() && info.ModTime().Before(cutoff) {
+			files = append(files, coldFile{path: path, info: info})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// writePackArchive writes files into a new tar.zst archive and returns the
+// manifest entries. A file that can't be read aborts the whole archive.
+// Files are read through the copy engine, which limits the rate and reports
+// progress across all files.
+func writePackArchive(archivePath, root string, files []coldFile, opts copyOptions) ([]packedFile, error) {
+	out, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	counter := &countingWriter{w: out}
+	encoder, err := zstd.NewWriter(counter, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(encoder)
+
+	var total, done int64
+	for _, file := range files {
+		total += file.info.Size()
+	}
+	progress := opts.Progress
+	fileOpts := opts
+
+	var packed []packedFile
+	for _, file := range files {
+		if progress != nil {
+			base := done
+			fileOpts.Progress = func(copied, _ int64) {
+				progress(base+copied, total)
+			}
+		}
+		done += file.info.Size()
+
+		rel, err := filepath.Rel(root, file.path)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := packedFile{
+			Path:    rel,
+			Size:    file.info.Size(),
+			Mode:    file.info.Mode().Perm(),
+			ModTime: file.info.ModTime(),
+			Offset:  counter.n,
+		}
+		entry.SHA256, err = writePackEntry(tw, file, rel, fileOpts)
+		if err != nil {
+			return nil, fmt.Errorf("packing %s: %v", file.path, err)
+		}
+
+		// End the frame so the next entry starts a new one
+		if err := tw.Flush(); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		entry.Length = counter.n - entry.Offset
+		encoder.Reset(counter)
+
+		packed = append(packed, entry)
+	}
+
+	// The end-of-archive marker goes into a final frame of its own
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return packed, out.Close()
+}
+
+// writePackEntry writes one file as a tar entry and returns its SHA-256
+func writePackEntry(tw *tar.Writer, file coldFile, rel string, opts copyOptions) (string, error) {
+	in, err := os.Open(file.path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	header, err := tar.FileInfoHeader(file.info, "")
+	if err != nil {
+		return "", err
+	}
+	header.Name = filepath.ToSlash(rel)
+	if err := tw.WriteHeader(header); err != nil {
+		return "", err
+	}
+
+	return copyStream(tw, in, header.Size, opts)
+}
+
+// readPackedFile decompresses a single file from an archive into w and
+// verifies its checksum
+func readPackedFile(archive *os.File, file packedFile, w io.Writer) error {
+	decoder, err := zstd.NewReader(io.NewSectionReader(archive, file.Offset, file.Length),
+		zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	tr := tar.NewReader(decoder)
+	header, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if header.Name != filepath.ToSlash(file.Path) {
+		return fmt.Errorf("archive entry is %s, expected %s", header.Name, file.Path)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), tr); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s", file.Path)
+	}
+	return nil
+}
+
+// verifyPackArchive reads every file back from an archive
+func verifyPackArchive(manifest *packManifest) error {
+	archive, err := os.Open(manifest.Archive)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, file := range manifest.Files {
+		if err := readPackedFile(archive, file, io.Discard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save stores a pack manifest in the state store
+func (m *packManifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.Put("packs", m.ID+".json", data)
+}
+
+// loadPackManifests returns all pack manifests, newest first
+func loadPackManifests() ([]*packManifest, error) {
+	items, err := state.List("packs")
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []*packManifest
+	for _, item := range items {
+		data, err := state.Get("packs", item.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest packManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", item.Key, err)
+		}
+		manifests = append(manifests, &manifest)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Created.After(manifests[j].Created)
+	})
+	return manifests, nil
+}
+
+// findPackManifest finds a pack by its ID or archive path
+func findPackManifest(pack string) (*packManifest, error) {
+	manifests, err := loadPackManifests()
+	if err != nil {
+		return nil, err
+	}
+
+	archive, _ := filepath.Abs(pack)
+	for _, manifest := range manifests {
+		if manifest.ID == pack || manifest.Archive == archive {
+			return manifest, nil
+		}
+	}
+	return nil, fmt.Errorf("no pack %s, see dirmon packs", pack)
+}
+
+// packDirectory bundles files not modified for a while into a compressed
+// archive, verifies it and deletes the originals
+func packDirectory(path string, opts packOptions) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	age, err := parseAge(opts.OlderThan)
+	if err != nil {
+		return err
+	}
+
+	output := opts.Output
+	if output == "" {
+		output, err = packsDir()
+	} else {
+		output, err = filepath.Abs(output)
+		if err == nil {
+			err = os.MkdirAll(output, 0755)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	files, err := findColdFiles(absPath, output, time.Now().Add(-age))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Printf("No files older than %s in %s\n", opts.OlderThan, absPath)
+		return nil
+	}
+
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.info.Size()
+	}
+
+	if opts.DryRun {
+		fmt.Printf("Files older than %s in %s:\n", opts.OlderThan, absPath)
+		fmt.Println(strings.Repeat("-", 80))
+		fmt.Printf("%-50s %-12s %s\n", "FILE", "SIZE", "MODIFIED")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, file := range files {
+			rel, _ := filepath.Rel(absPath, file.path)
+			fmt.Printf("%-50s %-12s %s\n", truncateString(rel, 49),
+				formatSize(file.info.Size()), file.info.ModTime().Format("2006-01-02"))
+		}
+		fmt.Println(strings.Repeat("-", 80))
+		fmt.Printf("Would pack %d files (%s)\n", len(files), formatSize(totalSize))
+		return nil
+	}
+
+	fmt.Printf("Found %d files older than %s in %s (%s)\n", len(files), opts.OlderThan, absPath, formatSize(totalSize))
+	if !opts.Yes {
+		if opts.Keep {
+			fmt.Print("Pack them into an archive? (y/N): ")
+		} else {
+			fmt.Print("Pack them into an archive and delete the originals? (y/N): ")
+		}
+		var response string
+		fmt.Scanln(&response)
+
+		if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+			fmt.Println("Operation cancelled")
+			return nil
+		}
+	}
+
+	now := time.Now()
+	manifest := &packManifest{
+		ID:        filepath.Base(absPath) + "-" + now.Format("20060102-150405"),
+		Created:   now,
+		Root:      absPath,
+		OlderThan: opts.OlderThan,
+	}
+	manifest.Archive = filepath.Join(output, manifest.ID+".tar.zst")
+
+	copyOpts := copyOptions{
+		BytesPerSecond: opts.BytesPerSecond,
+		Progress: func(copied, total int64) {
+			fmt.Printf("\rPacking: %s of %s (%.0f%%)   ", formatSize(copied), formatSize(total), percentOf(copied, total))
+		},
+	}
+	manifest.Files, err = writePackArchive(manifest.Archive, absPath, files, copyOpts)
+	fmt.Println()
+	if err == nil {
+		err = verifyPackArchive(manifest)
+	}
+	if err == nil {
+		err = manifest.save()
+	}
+	if err != nil {
+		os.Remove(manifest.Archive)
+		return err
+	}
+
+	archiveSize := int64(0)
+	if info, err := os.Stat(manifest.Archive); err == nil {
+		archiveSize = info.Size()
+	}
+	fmt.Printf("Packed %d files (%s) into %s (%s)\n",
+		len(manifest.Files), formatSize(totalSize), manifest.Archive, formatSize(archiveSize))
+
+	if opts.Keep {
+		return nil
+	}
+
+	// Only delete files that haven't changed since they were read
+	var freed int64
+	deleted := 0
+	for _, file := range files {
+		info, err := os.Lstat(file.path)
+		if err != nil || info.Size() != file.info.Size() || !info.ModTime().Equal(file.info.ModTime()) {
+			fmt.Printf("Keeping %s: changed while packing\n", file.path)
+			continue
+		}
+		if err := os.Remove(file.path); err != nil {
+			fmt.Printf("Error deleting %s: %v\n", file.path, err)
+			continue
+		}
+		freed += file.info.Size()
+		deleted++
+	}
+
+	// The archive's own size is subtracted when it takes up space on the
+	// same filesystem. Incompressible data may not free anything.
+	if deleted > 0 {
+		reclaimed := freed
+		if sameFilesystem(manifest.Archive, absPath) {
+			reclaimed = max(freed-archiveSize, 0)
+		}
+		recordSavings(savingsEntry{Action: "pack", Rule: manifest.ID, Dir: absPath,
+			Files: deleted, Bytes: reclaimed})
+	}
+
+	fmt.Printf("Deleted %d originals (%s), the archive takes %s. Restore with: dirmon unpack %s\n",
+		deleted, formatSize(freed), formatSize(archiveSize), manifest.ID)
+	return nil
+}
+
+// restorePackedFile extracts a file from an archive to dest. Existing files
+// are never overwritten.
+func restorePackedFile(archive *os.File, file packedFile, dest string) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(dest), ".dirmon-extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	err = readPackedFile(archive, file, temp)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(temp.Name(), file.Mode); err != nil {
+		return err
+	}
+	if err := os.Chtimes(temp.Name(), file.ModTime, file.ModTime); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), dest)
+}
+
+// restorePackedFiles extracts files of one pack to their original paths, or
+// below to if set, and returns how many were restored
+func restorePackedFiles(manifest *packManifest, files []packedFile, to string) (int, error) {
+	archive, err := os.Open(manifest.Archive)
+	if err != nil {
+		return 0, err
+	}
+	defer archive.Close()
+
+	restored := 0
+	for _, file := range files {
+		dest := manifest.originalPath(file)
+		if to != "" {
+			dest = filepath.Join(to, file.Path)
+		}
+
+		// Files restored earlier, e.g. with extract, count as restored
+		if sum, err := fileSHA256(dest); err == nil && sum == file.SHA256 {
+			fmt.Printf("Already restored %s\n", dest)
+			restored++
+			continue
+		}
+
+		if err := restorePackedFile(archive, file, dest); err != nil {
+			fmt.Printf("Error restoring %s: %v\n", dest, err)
+			continue
+		}
+		fmt.Printf("Restored %s\n", dest)
+		restored++
+	}
+	return restored, nil
+}
+
+// unpackArchive restores every file of a pack. With remove, the archive and
+// its manifest are deleted once all files are back.
+func unpackArchive(pack, to string, remove bool) error {
+	manifest, err := findPackManifest(pack)
+	if err != nil {
+		return err
+	}
+	if to != "" {
+		if to, err = filepath.Abs(to); err != nil {
+			return err
+		}
+	}
+
+	restored, err := restorePackedFiles(manifest, manifest.Files, to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d of %d files from %s\n", restored, len(manifest.Files), manifest.ID)
+
+	if !remove {
+		return nil
+	}
+	if restored < len(manifest.Files) {
+		return fmt.Errorf("not all files were restored, keeping %s", manifest.Archive)
+	}
+
+	if err := os.Remove(manifest.Archive); err != nil {
+		return err
+	}
+	if err := state.Delete("packs", manifest.ID+".json"); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", manifest.Archive)
+	return nil
+}
+
+// extractPackedPath restores a packed file, or all packed files below a
+// directory, from the newest pack that contains them
+func extractPackedPath(path, to string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if to != "" {
+		if to, err = filepath.Abs(to); err != nil {
+			return err
+		}
+	}
+
+	manifests, err := loadPackManifests()
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	total := 0
+	for _, manifest := range manifests {
+		var files []packedFile
+		for _, file := range manifest.Files {
+			original := manifest.originalPath(file)
+			if original != absPath && !isAncestorPath(absPath, original) {
+				continue
+			}
+			if found[original] {
+				continue // Already restored from a newer pack
+			}
+			found[original] = true
+			files = append(files, file)
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		fmt.Printf("Extracting %d files from %s\n", len(files), manifest.ID)
+		restored, err := restorePackedFiles(manifest, files, to)
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", manifest.Archive, err)
+		}
+		total += restored
+	}
+
+	if len(found) == 0 {
+		return fmt.Errorf("%s is not in any pack", absPath)
+	}
+	fmt.Printf("Restored %d of %d files\n", total, len(found))
+	return nil
+}
+
+// viewPacks lists the pack archives
+func viewPacks() error {
+	manifests, err := loadPackManifests()
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		fmt.Println("No packs")
+		return nil
+	}
+
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("%-35s %-17s %-8s %-12s %-12s %s\n", "PACK", "CREATED", "FILES", "ORIGINAL", "ARCHIVE", "ROOT")
+	fmt.Println(strings.Repeat("-", 100))
+	for _, manifest := range manifests {
+		var size int64
+		for _, file := range manifest.Files {
+			size += file.Size
+		}
+
+		archiveSize := "missing"
+		if info, err := os.Stat(manifest.Archive); err == nil {
+			archiveSize = formatSize(info.Size())
+		}
+
+		fmt.Printf("%-35s %-17s %-8d %-12s %-12s %s\n", truncateString(manifest.ID, 34),
+			manifest.Created.Local().Format("2006-01-02 15:04"), len(manifest.Files),
+			formatSize(size), archiveSize, manifest.Root)
+	}
+	return nil
+}
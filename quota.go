@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// countFiles returns the number of files and directories below a directory,
+// the unit filesystems run out of when a tree holds millions of small files
+func countFiles(absPath string) (int64, error) {
+	ignore := newIgnoreMatcher(absPath)
+
+	var count int64
+	err := filepath.WalkDir(absPath, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if path == absPath {
+				return err
+			}
+			return nil // Skip files we can't access
+		}
+		if path == absPath {
+			return nil
+		}
+
+		if ignore.Match(path, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// setFileQuota limits the number of files in a directory
+func setFileQuota(path string, maxFiles int64) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", absPath)
+	}
+	if maxFiles <= 0 {
+		return fmt.Errorf("the file limit must be positive")
+	}
+
+	if appConfig.FileQuotas == nil {
+		appConfig.FileQuotas = make(map[string]int64)
+	}
+	appConfig.FileQuotas[absPath] = maxFiles
+	if err := saveConfig(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Directory %s is now limited to %d files\n", absPath, maxFiles)
+	return nil
+}
+
+// removeFileQuota removes the file limit of a directory
+func removeFileQuota(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := appConfig.FileQuotas[absPath]; !ok {
+		return fmt.Errorf("%s has no file limit", absPath)
+	}
+	delete(appConfig.FileQuotas, absPath)
+	if err := saveConfig(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed the file limit of %s\n", absPath)
+	return nil
+}
+
+// checkFileQuotas counts the files in every directory with a limit and
+// returns an error if any is over it, so the command can alert from cron
+func checkFileQuotas() error {
+	if len(appConfig.FileQuotas) == 0 {
+		fmt.Println("No file limits configured")
+		return nil
+	}
+
+	dirs := make([]string, 0, len(appConfig.FileQuotas))
+	for dir := range appConfig.FileQuotas {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	fmt.Println("File limits (files and directories, ignored paths excluded):")
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("%-50s %12s %12s %s\n", "DIRECTORY", "FILES", "LIMIT", "USED")
+	fmt.Println(strings.Repeat("-", 80))
+
+	over := 0
+	for _, dir := range dirs {
+		limit := appConfig.FileQuotas[dir]
+		count, err := countFiles(dir)
+		if err != nil {
+			fmt.Printf("%-50s %v\n", truncateString(dir, 50), err)
+			continue
+		}
+
+		used := fmt.Sprintf("%.1f%%", percentOf(count, limit))
+		if count > limit {
+			used += " OVER"
+			over++
+		}
+		fmt.Printf("%-50s %12d %12d %s\n", truncateString(dir, 50), count, limit, used)
+	}
+
+	if over > 0 {
+		return fmt.Errorf("%d directories are over their file limit", over)
+	}
+	return nil
+}
+
+// fileQuota is the running file count of a directory with a limit
+type fileQuota struct {
+	dir   string
+	limit int64
+	count int64
+	over  bool
+}
+
+// quotaVerifyInterval is how often the file counts of limited directories
+// are verified by walking them
+const quotaVerifyInterval = time.Minute
+
+// quotaTracker keeps file counts of monitored directories with limits up to
+// date and alerts when a count crosses its limit. Events only cover the top
+// level of a directory, since roots are watched non-recursively, so counts
+// are verified by walking the directories regularly and whenever an event
+// may have crossed the limit. Walks run on their own goroutine so they
+// never hold up events.
+type quotaTracker struct {
+	mu     sync.Mutex
+	quotas map[string]*fileQuota
+	verify chan string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newQuotaTracker counts the files of the roots that have a limit. It
+// returns nil if none has, which is safe to use.
+func newQuotaTracker(roots []string) *quotaTracker {
+	tracker := &quotaTracker{quotas: make(map[string]*fileQuota)}
+	for _, root := range roots {
+		limit, ok := appConfig.FileQuotas[root]
+		if !ok {
+			continue
+		}
+
+		count, err := countFiles(root)
+		if err != nil {
+			fmt.Printf("Error counting files in %s: %v\n", root, err)
+			continue
+		}
+
+		quota := &fileQuota{dir: root, limit: limit, count: count, over: count > limit}
+		tracker.quotas[root] = quota
+		filesGauge.Add(context.Background(), count, quotaAttrs(root))
+
+		fmt.Printf("File limit of %s: %d of %d files\n", root, count, limit)
+		if quota.over {
+			fmt.Printf("[QUOTA] %s is over its limit of %d files (%d files)\n", root, limit, count)
+		}
+	}
+
+	if len(tracker.quotas) == 0 {
+		return nil
+	}
+
+	tracker.verify = make(chan string, len(tracker.quotas))
+	tracker.stop = make(chan struct{})
+	tracker.done = make(chan struct{})
+	go tracker.run()
+	return tracker
+}
+
+// run verifies counts on request and on every interval until Close
+func (t *quotaTracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(quotaVerifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case dir := <-t.verify:
+			t.verifyCount(dir)
+		case <-ticker.C:
+			for dir := range t.quotas {
+				t.verifyCount(dir)
+			}
+		}
+	}
+}
+
+// Close stops verifying counts. It is safe to call on a nil tracker.
+func (t *quotaTracker) Close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+// Observe updates the count of the event's root and asks for the count to
+// be verified when it may have crossed the limit
+func (t *quotaTracker) Observe(event Event) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	quota, ok := t.quotas[event.Root]
+	if !ok {
+		return
+	}
+
+	verify := false
+	switch event.Op {
+	case "CREATED":
+		quota.add(1)
+		if info, err := os.Lstat(event.Path); err == nil && info.IsDir() {
+			// Directories can be moved in with their contents
+			verify = true
+		}
+	case "DELETED", "RENAMED":
+		// Renames are reported for the old name, the new name is created
+		quota.add(-1)
+	default:
+		return
+	}
+
+	if verify || (quota.count > quota.limit) != quota.over {
+		select {
+		case t.verify <- quota.dir:
+		default: // A verification is already queued
+		}
+	}
+}
+
+// verifyCount walks a directory, corrects its count and prints an alert
+// when the count crossed the limit in either direction
+func (t *quotaTracker) verifyCount(dir string) {
+	count, err := countFiles(dir)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	quota := t.quotas[dir]
+	quota.add(count - quota.count)
+	if over := quota.count > quota.limit; over != quota.over {
+		quota.over = over
+		if over {
+			fmt.Printf("[QUOTA] %s is over its limit of %d files (%d files)\n", quota.dir, quota.limit, quota.count)
+		} else {
+			fmt.Printf("[QUOTA] %s is back under its limit of %d files (%d files)\n", quota.dir, quota.limit, quota.count)
+		}
+	}
+}
+
+// add changes the count and the exported metric
+func (q *fileQuota) add(delta int64) {
+	if q.count+delta < 0 {
+		delta = -q.count
+	}
+	q.count += delta
+	filesGauge.Add(context.Background(), delta, quotaAttrs(q.dir))
+}
+
+// quotaAttrs returns the metric attributes for a directory's file count
+func quotaAttrs(dir string) metric.AddOption {
+	return metric.WithAttributes(attribute.String("dir", dir))
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fsIocFiemap is FS_IOC_FIEMAP, _IOWR('f', 11, struct fiemap)
+	fsIocFiemap = 0xC020660B
+
+	fiemapFlagSync     = 0x1
+	fiemapExtentLast   = 0x1
+	fiemapExtentShared = 0x2000
+
+	// fiemapBatch is how many extents are requested per ioctl
+	fiemapBatch = 128
+
+	// dedupeChunk is the most data shared per FIDEDUPERANGE call; some
+	// filesystems cap it at 16 MB
+	dedupeChunk = 16 * 1024 * 1024
+)
+
+// fiemapHeader is struct fiemap without its trailing extents
+type fiemapHeader struct {
+	Start         uint64
+	Length        uint64
+	Flags         uint32
+	MappedExtents uint32
+	ExtentCount   uint32
+	Reserved      uint32
+}
+
+// fiemapExtent is struct fiemap_extent
+type fiemapExtent struct {
+	Logical    uint64
+	Physical   uint64
+	Length     uint64
+	Reserved64 [2]uint64
+	Flags      uint32
+	Reserved   [3]uint32
+}
+
+// sharedExtents returns a key describing where a file's data is stored, if
+// all of its extents are shared with other files (as after a reflink copy
+// on btrfs or XFS). Files with the same key share all their data.
+func sharedExtents(path string) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	headerSize := unsafe.Sizeof(fiemapHeader{})
+	extentSize := unsafe.Sizeof(fiemapExtent{})
+	buf := make([]byte, headerSize+fiemapBatch*extentSize)
+	header := (*fiemapHeader)(unsafe.Pointer(&buf[0]))
+
+	var key strings.Builder
+	var start uint64
+	for {
+		*header = fiemapHeader{Start: start, Length: ^uint64(0), Flags: fiemapFlagSync, ExtentCount: fiemapBatch}
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&buf[0])))
+		if errno != 0 || header.MappedExtents == 0 {
+			break
+		}
+
+		for i := uint32(0); i < header.MappedExtents; i++ {
+			extent := (*fiemapExtent)(unsafe.Pointer(&buf[headerSize+uintptr(i)*extentSize]))
+			if extent.Flags&fiemapExtentShared == 0 {
+				return "", false
+			}
+			fmt.Fprintf(&key, "%x+%x@%x,", extent.Logical, extent.Length, extent.Physical)
+
+			if extent.Flags&fiemapExtentLast != 0 {
+				return key.String(), true
+			}
+			start = extent.Logical + extent.Length
+		}
+	}
+	return "", false
+}
+
+// reflinkFile makes dst share the data of src, which must have identical
+// content. The kernel compares the data itself and refuses if it differs,
+// so nothing is lost if either file changed since it was hashed.
+func reflinkFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	for offset := uint64(0); offset < uint64(info.Size()); {
+		length := min(uint64(info.Size())-offset, dedupeChunk)
+		dedupe := &unix.FileDedupeRange{
+			Src_offset: offset,
+			Src_length: length,
+			Info:       []unix.FileDedupeRangeInfo{{Dest_fd: int64(out.Fd()), Dest_offset: offset}},
+		}
+
+		if err := unix.IoctlFileDedupeRange(int(in.Fd()), dedupe); err != nil {
+			if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTTY) ||
+				errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EXDEV) {
+				return errReflinkUnsupported
+			}
+			return err
+		}
+
+		result := dedupe.Info[0]
+		switch {
+		case result.Status == unix.FILE_DEDUPE_RANGE_DIFFERS:
+			return fmt.Errorf("content differs from %s", src)
+		case result.Status < 0:
+			return unix.Errno(-result.Status)
+		case result.Bytes_deduped == 0:
+			return fmt.Errorf("the filesystem shared no data")
+		}
+		offset += result.Bytes_deduped
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+// sharedExtents is only supported on Linux, through FIEMAP
+func sharedExtents(path string) (string, bool) {
+	return "", false
+}
+
+// reflinkFile is only supported on Linux, through FIDEDUPERANGE
+func reflinkFile(src, dst string) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"dirmon/client"
+
+	"github.com/urfave/cli/v2"
+)
+
+// remotePollInterval is how often job progress is polled in remote mode
+const remotePollInterval = 500 * time.Millisecond
+
+// remoteCommands are the commands that can run against a server given with
+// --remote; everything else only makes sense on the local machine
+var remoteCommands = map[string]bool{
+	"show-dirs":       true,
+	"add-dir":         true,
+	"events":          true,
+	"find-duplicates": true,
+	"disk-usage":      true,
+}
+
+// checkRemoteCommand rejects commands that don't support --remote
+func checkRemoteCommand(c *cli.Context) error {
+	if c.String("remote") == "" || c.NArg() == 0 {
+		return nil
+	}
+
+	command := c.App.Command(c.Args().First())
+	if command == nil || remoteCommands[command.Name] {
+		return nil
+	}
+	return fmt.Errorf("%s cannot be used with --remote", command.Name)
+}
+
+// remoteClient returns a client for the server given with --remote, or nil
+// when running locally
+func remoteClient(c *cli.Context) *client.Client {
+	addr := c.String("remote")
+	if addr == "" {
+		return nil
+	}
+	api := client.New(addr)
+	api.Token = c.String("token")
+	return api
+}
+
+// remotePath returns the directory argument of a remote command; unlike
+// local commands there is no default, since the server's working directory
+// is unlikely to be what the user means
+func remotePath(c *cli.Context) (string, error) {
+	if c.NArg() == 0 {
+		return "", fmt.Errorf("please specify a directory on the remote host")
+	}
+	return c.Args().Get(0), nil
+}
+
+// remoteShowDirs lists the server's monitored directories
+func remoteShowDirs(api *client.Client) error {
+	dirs, err := api.MonitoredDirs(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if len(dirs) == 0 {
+		fmt.Println("No directories are being monitored")
+		return nil
+	}
+
+	fmt.Printf("Monitored directories on %s:\n", api.BaseURL)
+	fmt.Println(strings.Repeat("-", 80))
+	for i, dir := range dirs {
+		fmt.Printf("%d. %s\n", i+1, dir)
+	}
+	return nil
+}
+
+// remoteAddDir adds a directory on the server to its monitored list
+func remoteAddDir(api *client.Client, path string) error {
+	if err := api.AddDir(context.Background(), path); err != nil {
+		return err
+	}
+	fmt.Printf("Directory %s has been added to the monitored list on %s\n", path, api.BaseURL)
+	return nil
+}
+
+// remoteQueryEvents runs an SQL query against the server's event database
+func remoteQueryEvents(api *client.Client, query string) error {
+	result, err := api.QueryEvents(context.Background(), query)
+	if err != nil {
+		return err
+	}
+
+	printQueryResult(&queryResult{Columns: result.Columns, Rows: result.Rows})
+	return nil
+}
+
+// runRemoteJob starts a scan job on the server and waits for it, printing
+// its progress. Interrupting cancels the job on the server as well.
+func runRemoteJob(api *client.Client, jobType, path string, result any) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	job, err := api.StartJob(ctx, jobType, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Started %s job %s for %s on %s\n", jobType, job.ID, job.Path, api.BaseURL)
+
+	id := job.ID
+	lastPhase := ""
+	job, err = api.WaitJob(ctx, id, remotePollInterval, func(job *client.Job) {
+		if job.Progress.Phase != "" && job.Progress.Phase != lastPhase {
+			fmt.Printf("[%s] %s\n", job.State, job.Progress.Phase)
+			lastPhase = job.Progress.Phase
+		}
+	})
+	if ctx.Err() != nil {
+		// The local context is gone, so cancel with a fresh one
+		if _, err := api.CancelJob(context.Background(), id); err != nil {
+			return fmt.Errorf("interrupted, but job %s could not be canceled: %v", id, err)
+		}
+		return fmt.Errorf("interrupted, job %s canceled", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if job.State != "done" {
+		return fmt.Errorf("job %s %s: %s", id, job.State, job.Error)
+	}
+	return api.JobResult(context.Background(), id, result)
+}
+
+// remoteFindDuplicates finds duplicate files in a directory on the server
+func remoteFindDuplicates(api *client.Client, path string) error {
+	var result []client.DuplicateGroup
+	if err := runRemoteJob(api, "find-duplicates", path, &result); err != nil {
+		return err
+	}
+
+	groups := make([]duplicateGroup, len(result))
+	for i, group := range result {
+		groups[i] = duplicateGroup{
+			Hash:     group.Hash,
+			Size:     group.Size,
+			Files:    group.Files,
+			Original: group.Original,
+			Reason:   group.Reason,
+		}
+		for _, shared := range group.Shared {
+			groups[i].Shared = append(groups[i].Shared, sharedCopy{File: shared.File, With: shared.With, Kind: shared.Kind})
+		}
+	}
+	printDuplicateGroups(groups)
+	return nil
+}
+
+// remoteDiskUsage analyzes disk usage of a directory on the server
+func remoteDiskUsage(api *client.Client, path string) error {
+	var report client.DiskUsageReport
+	if err := runRemoteJob(api, "disk-usage", path, &report); err != nil {
+		return err
+	}
+
+	usage := newDiskUsage(report.Root, diskUsageOptions{})
+	usage.totalSize = report.TotalSize
+	for _, entry := range report.Types {
+		usage.typeStats[entry.Name] = entry.Size
+	}
+	for _, entry := range report.Directories {
+		usage.dirStats[entry.Name] = entry.Size
+	}
+	printDiskUsage(usage)
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadHookRules reads hook rules from a file, either as a JSON array of rules
+// or as a config file with a "hooks" list
+func loadHookRules(path string) ([]HookRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []HookRule
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &rules)
+	} else {
+		var config Config
+		err = json.Unmarshal(data, &config)
+		rules = config.Hooks
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// describeHookAction returns what a rule would do for an event
+func describeHookAction(hook *compiledHook, event Event) string {
+	action, err := hook.expand(event)
+	if err != nil {
+		return fmt.Sprintf("error expanding templates: %v", err)
+	}
+
+	if action.Move != "" {
+		return "move to " + action.Move
+	}
+	if action.Copy != "" {
+		return "copy to " + action.Copy
+	}
+
+	args := make([]string, len(action.Command))
+	for i, arg := range action.Command {
+		args[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\$") {
+			args[i] = strconv.Quote(arg)
+		}
+	}
+	description := "run " + strings.Join(args, " ")
+	if len(action.Env) > 0 {
+		description += " with " + strings.Join(action.Env, " ")
+	}
+	return description
+}
+
+// replayEvents feeds events recorded with --event-log through the hook rules
+// without running anything, printing which rules match each event and what
+// they would do. rulesFile replaces the configured hooks, so that changed
+// rules can be checked before they go live.
+func replayEvents(eventLog, rulesFile string, exec []string, verbose bool) error {
+	rules := appConfig.Hooks
+	if rulesFile != "" {
+		var err error
+		rules, err = loadHookRules(rulesFile)
+		if err != nil {
+			return err
+		}
+	}
+	rules = append(rules, shellHooks(exec)...)
+	if len(rules) == 0 {
+		return fmt.Errorf("no hook rules to replay against, configure hooks or use --rules or --exec")
+	}
+
+	hooks, err := compileHooks(rules)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if eventLog != "-" {
+		file, err := os.Open(eventLog)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	fmt.Printf("Replaying %s against %d rules (dry run, nothing is executed)\n", eventLog, len(hooks))
+	fmt.Println(strings.Repeat("-", 80))
+
+	// Counted by position, as rules may share a name
+	matchesByRule := make([]int, len(hooks))
+	events, matched, invalid := 0, 0, 0
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil || event.Path == "" {
+			invalid++
+			continue
+		}
+		events++
+
+		var actions []string
+		for i, hook := range hooks {
+			if hook.matches(event) {
+				matchesByRule[i]++
+				actions = append(actions, fmt.Sprintf("  -> %s: %s", hook.Name, describeHookAction(hook, event)))
+			}
+		}
+
+		if len(actions) == 0 && !verbose {
+			continue
+		}
+		if len(actions) > 0 {
+			matched++
+		}
+
+		fmt.Printf("[%s] %s - %s\n", event.Time.Local().Format("2006-01-02 15:04:05"), event.Op, event.Path)
+		if len(actions) == 0 {
+			fmt.Println("  (no matching rules)")
+		}
+		for _, action := range actions {
+			fmt.Println(action)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("%d events, %d matched at least one rule", events, matched)
+	if invalid > 0 {
+		fmt.Printf(", %d invalid lines skipped", invalid)
+	}
+	fmt.Println()
+
+	fmt.Printf("\n%-30s %s\n", "RULE", "MATCHES")
+	for i, hook := range hooks {
+		fmt.Printf("%-30s %d\n", truncateString(hook.Name, 30), matchesByRule[i])
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pruneInterval is how often the monitor commands prune dirmon's data stores
+const pruneInterval = time.Hour
+
+// RetentionPolicy limits how long and how much data dirmon keeps.
+// Zero values mean no limit.
+type RetentionPolicy struct {
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+	MaxSizeMB  int `json:"max_size_mb,omitempty"`
+}
+
+// cutoff returns the time before which data expires, or the zero time
+func (p RetentionPolicy) cutoff(now time.Time) time.Time {
+	if p.MaxAgeDays <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(p.MaxAgeDays) * 24 * time.Hour)
+}
+
+// maxBytes returns the size limit in bytes, or 0 for no limit
+func (p RetentionPolicy) maxBytes() int64 {
+	return int64(p.MaxSizeMB) * 1024 * 1024
+}
+
+// retentionFor returns the policy for events of a monitored directory: its
+// own max age if one is configured, otherwise the global policy. Size limits
+// always apply to a whole store.
+func (c *Config) retentionFor(dir string) RetentionPolicy {
+	policy := c.Retention
+	if override, ok := c.DirRetention[dir]; ok && override.MaxAgeDays > 0 {
+		policy.MaxAgeDays = override.MaxAgeDays
+	}
+	return policy
+}
+
+// eventExpired reports whether an event is older than its root's retention
+func eventExpired(event Event, now time.Time) bool {
+	cutoff := appConfig.retentionFor(event.Root).cutoff(now)
+	return !cutoff.IsZero() && event.Time.Before(cutoff)
+}
+
+// pruneEventLog removes expired events from a JSON lines event log and then
+// drops the oldest events until the log fits the global size limit
+func pruneEventLog(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var kept [][]byte
+	var keptSize, removed int64
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err == nil && eventExpired(event, now) {
+			removed++
+			continue
+		}
+
+		line := append([]byte(nil), scanner.Bytes()...)
+		kept = append(kept, line)
+		keptSize += int64(len(line)) + 1
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if maxBytes := appConfig.Retention.maxBytes(); maxBytes > 0 {
+		for len(kept) > 0 && keptSize > maxBytes {
+			keptSize -= int64(len(kept[0])) + 1
+			kept = kept[1:]
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	tmpPath := path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, line := range kept {
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	tmp.Close()
+
+	return removed, os.Rename(tmpPath, path)
+}
+
+// pruneEventDB removes expired events from an SQLite event database and then
+// deletes the oldest events until the database fits the global size limit
+func pruneEventDB(db *sql.DB) (int64, error) {
+	now := time.Now()
+	var removed int64
+
+	// Directories with their own max age first, then everything else
+	overridden := []any{}
+	for dir := range appConfig.DirRetention {
+		cutoff := appConfig.retentionFor(dir).cutoff(now)
+		overridden = append(overridden, dir)
+		if cutoff.IsZero() {
+			continue
+		}
+
+		result, err := db.Exec("DELETE FROM events WHERE root = ? AND time < ?",
+			dir, cutoff.UTC().Format(sqliteTimeFormat))
+		if err != nil {
+			return removed, err
+		}
+		n, _ := result.RowsAffected()
+		removed += n
+	}
+
+	if cutoff := appConfig.Retention.cutoff(now); !cutoff.IsZero() {
+		query := "DELETE FROM events WHERE time < ?"
+		args := []any{cutoff.UTC().Format(sqliteTimeFormat)}
+		if len(overridden) > 0 {
+			query += " AND root NOT IN (?" + strings.Repeat(", ?", len(overridden)-1) + ")"
+			args = append(args, overridden...)
+		}
+
+		result, err := db.Exec(query, args...)
+		if err != nil {
+			return removed, err
+		}
+		n, _ := result.RowsAffected()
+		removed += n
+	}
+
+	maxBytes := appConfig.Retention.maxBytes()
+	if maxBytes <= 0 {
+		return removed, nil
+	}
+
+	for {
+		size, err := eventTableSize(db)
+		if err != nil {
+			return removed, err
+		}
+		if size <= maxBytes {
+			return removed, nil
+		}
+
+		var count int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM events").Scan(&count); err != nil {
+			return removed, err
+		}
+		if count == 0 {
+			return removed, nil
+		}
+
+		// Drop the oldest tenth and reclaim the space
+		result, err := db.Exec("DELETE FROM events WHERE id IN (SELECT id FROM events ORDER BY time LIMIT ?)",
+			count/10+1)
+		if err != nil {
+			return removed, err
+		}
+		n, _ := result.RowsAffected()
+		removed += n
+
+		if _, err := db.Exec("VACUUM"); err != nil {
+			return removed, err
+		}
+	}
+}
+
+// eventTableSize returns the bytes used by the events table and its
+// indexes. The database may also hold dirmon's state (DIRMON_STATE=sqlite:),
+// which the size limit of the event history doesn't cover.
+func eventTableSize(db *sql.DB) (int64, error) {
+	var size int64
+	err := db.QueryRow(`SELECT COALESCE(SUM(pgsize), 0) FROM dbstat
+		WHERE name IN (SELECT name FROM sqlite_schema WHERE tbl_name = 'events')`).Scan(&size)
+	return size, err
+}
+
+// pruneStateLogs deletes audit and savings records older than the global
+// max age
+func pruneStateLogs() (int64, error) {
+	cutoff := appConfig.Retention.cutoff(time.Now())
+	if cutoff.IsZero() {
+		return 0, nil
+	}
+
+	var removed int64
+	for _, log := range stateLogs {
+		n, err := state.PruneLog(log, func(record []byte) bool {
+			var entry struct {
+				Time time.Time `json:"time"`
+			}
+			// Keep records that can't be read rather than losing them
+			return json.Unmarshal(record, &entry) != nil || !entry.Time.Before(cutoff)
+		})
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// pruneCheckpoints deletes scan checkpoints older than the global max age
+func pruneCheckpoints() (int64, error) {
+	cutoff := appConfig.Retention.cutoff(time.Now())
+	if cutoff.IsZero() {
+		return 0, nil
+	}
+
+	items, err := state.List("checkpoints")
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, item := range items {
+		if !item.Updated.Before(cutoff) {
+			continue
+		}
+		if state.Delete("checkpoints", item.Key) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// runPruning prunes the recorder's sinks and dirmon's own stores, printing
+// a line for every store that had anything removed
+func runPruning(recorder *eventRecorder) {
+	for _, sink := range recorder.sinks {
+		removed, err := sink.Prune()
+		if err != nil {
+			fmt.Printf("[ERROR] pruning %s: %v\n", sink.Name(), err)
+		} else if removed > 0 {
+			fmt.Printf("[%s] Pruned %d events from %s\n", time.Now().Format("15:04:05"), removed, sink.Name())
+		}
+	}
+
+	if removed, err := pruneCheckpoints(); err != nil {
+		fmt.Printf("[ERROR] pruning checkpoints: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("[%s] Pruned %d checkpoints\n", time.Now().Format("15:04:05"), removed)
+	}
+
+	if removed, err := pruneSnapshots(); err != nil {
+		fmt.Printf("[ERROR] pruning snapshots: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("[%s] Pruned %d snapshots\n", time.Now().Format("15:04:05"), removed)
+	}
+
+	if removed, err := pruneStateLogs(); err != nil {
+		fmt.Printf("[ERROR] pruning audit and savings logs: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("[%s] Pruned %d audit and savings records\n", time.Now().Format("15:04:05"), removed)
+	}
+}
+
+// startPruneJob prunes once now and then every pruneInterval while
+// monitoring, as long as a retention policy is configured. The returned
+// function stops pruning and waits for a run in progress, and must be called
+// before the recorder's sinks are closed.
+func startPruneJob(recorder *eventRecorder) (stop func()) {
+	if !appConfig.hasRetention() {
+		return func() {}
+	}
+
+	runPruning(recorder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				runPruning(recorder)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// hasRetention reports whether any retention limit is configured
+func (c *Config) hasRetention() bool {
+	if c.Retention.MaxAgeDays > 0 || c.Retention.MaxSizeMB > 0 {
+		return true
+	}
+	for _, policy := range c.DirRetention {
+		if policy.MaxAgeDays > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneStores prunes the given event stores and dirmon's own data once,
+// for the prune command
+func pruneStores(eventLog, eventDB string) error {
+	if !appConfig.hasRetention() {
+		return fmt.Errorf("no retention policy configured, set one with the retention command")
+	}
+
+	recorder, err := newEventRecorder(monitorOptions{EventLog: eventLog, EventDB: eventDB})
+	if err != nil {
+		return err
+	}
+	defer recorder.Close()
+
+	runPruning(recorder)
+	return nil
+}
+
+// setRetention updates the global retention policy, or the max age of a
+// single monitored directory when dir is given
+func setRetention(dir string, maxAgeDays, maxSizeMB int) error {
+	if dir == "" {
+		if maxAgeDays >= 0 {
+			appConfig.Retention.MaxAgeDays = maxAgeDays
+		}
+		if maxSizeMB >= 0 {
+			appConfig.Retention.MaxSizeMB = maxSizeMB
+		}
+	} else {
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if maxSizeMB >= 0 {
+			return fmt.Errorf("size limits apply to whole stores and can't be set per directory")
+		}
+
+		if appConfig.DirRetention == nil {
+			appConfig.DirRetention = make(map[string]RetentionPolicy)
+		}
+		if maxAgeDays > 0 {
+			appConfig.DirRetention[absPath] = RetentionPolicy{MaxAgeDays: maxAgeDays}
+		} else if maxAgeDays == 0 {
+			delete(appConfig.DirRetention, absPath)
+		}
+	}
+
+	if err := saveConfig(); err != nil {
+		return err
+	}
+
+	viewRetention()
+	return nil
+}
+
+// viewRetention prints the configured retention policies
+func viewRetention() {
+	describe := func(p RetentionPolicy) string {
+		age, size := "unlimited", "unlimited"
+		if p.MaxAgeDays > 0 {
+			age = fmt.Sprintf("%d days", p.MaxAgeDays)
+		}
+		if p.MaxSizeMB > 0 {
+			size = fmt.Sprintf("%d MB", p.MaxSizeMB)
+		}
+		return fmt.Sprintf("max age %s, max size %s", age, size)
+	}
+
+	fmt.Printf("Default retention: %s\n", describe(appConfig.Retention))
+	for dir, policy := range appConfig.DirRetention {
+		fmt.Printf("%s: max age %d days\n", dir, policy.MaxAgeDays)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// savingsEntry records space reclaimed by one cleanup action
+type savingsEntry struct {
+	Time time.Time `json:"time"`
+
+	// Action is what reclaimed the space: cleanup, dedupe, reflink, pack,
+	// system-clean or hook
+	Action string `json:"action"`
+
+	// Rule is the hook, cleanup reason or system-clean location responsible
+	Rule string `json:"rule,omitempty"`
+
+	// Dir is the directory the space was reclaimed in
+	Dir   string `json:"dir"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// savingsMu serializes appends by concurrent hook workers
+var savingsMu sync.Mutex
+
+// recordSavings appends an entry to the state store's savings log,
+// ~/.dirmon/savings.log with the file store. Failing to record never fails
+// the action itself, so errors are only reported.
+func recordSavings(entry savingsEntry) {
+	if entry.Bytes <= 0 && entry.Files == 0 {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	savingsMu.Lock()
+	defer savingsMu.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = state.Append("savings", data)
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] recording savings: %v\n", err)
+	}
+}
+
+// savingsRecorder sums up the savings of a batch action per directory and
+// rule, so that one entry is logged per pair instead of one per file
+type savingsRecorder struct {
+	action string
+	totals map[[2]string]*savingsEntry
+	order  [][2]string
+}
+
+func newSavingsRecorder(action string) *savingsRecorder {
+	return &savingsRecorder{action: action, totals: make(map[[2]string]*savingsEntry)}
+}
+
+// Add counts a file removed or shrunk by bytes
+func (r *savingsRecorder) Add(dir, rule string, bytes int64) {
+	key := [2]string{dir, rule}
+	entry, ok := r.totals[key]
+	if !ok {
+		entry = &savingsEntry{Action: r.action, Rule: rule, Dir: dir}
+		r.totals[key] = entry
+		r.order = append(r.order, key)
+	}
+	entry.Files++
+	entry.Bytes += bytes
+}
+
+// Save records the totals
+func (r *savingsRecorder) Save() {
+	now := time.Now()
+	for _, key := range r.order {
+		entry := *r.totals[key]
+		entry.Time = now
+		recordSavings(entry)
+	}
+}
+
+// loadSavings reads the savings log, skipping entries before since
+func loadSavings(since time.Time) ([]savingsEntry, error) {
+	var entries []savingsEntry
+	err := state.ReadLog("savings", func(record []byte) {
+		var entry savingsEntry
+		if err := json.Unmarshal(record, &entry); err != nil {
+			return // Skip lines cut off by a crash
+		}
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	})
+	return entries, err
+}
+
+// savingsTotal is the space reclaimed for one directory or rule
+type savingsTotal struct {
+	Name    string    `json:"name"`
+	Actions int       `json:"actions"`
+	Files   int       `json:"files"`
+	Bytes   int64     `json:"bytes"`
+	Last    time.Time `json:"last"`
+}
+
+// savingsReport summarizes the savings log
+type savingsReport struct {
+	Since       *time.Time     `json:"since,omitempty"`
+	Files       int            `json:"files"`
+	Bytes       int64          `json:"bytes"`
+	ByDirectory []savingsTotal `json:"by_directory"`
+	ByRule      []savingsTotal `json:"by_rule"`
+}
+
+// summarizeSavings totals entries per directory and per rule, largest first.
+// A rule is named after its action, followed by the hook, cleanup reason or
+// location where there is one.
+func summarizeSavings(entries []savingsEntry) savingsReport {
+	var report savingsReport
+	byDir := make(map[string]*savingsTotal)
+	byRule := make(map[string]*savingsTotal)
+
+	add := func(totals map[string]*savingsTotal, name string, entry savingsEntry) {
+		total, ok := totals[name]
+		if !ok {
+			total = &savingsTotal{Name: name}
+			totals[name] = total
+		}
+		total.Actions++
+		total.Files += entry.Files
+		total.Bytes += entry.Bytes
+		if entry.Time.After(total.Last) {
+			total.Last = entry.Time
+		}
+	}
+
+	for _, entry := range entries {
+		report.Files += entry.Files
+		report.Bytes += entry.Bytes
+
+		rule := entry.Action
+		if entry.Rule != "" {
+			rule += ": " + entry.Rule
+		}
+		add(byDir, entry.Dir, entry)
+		add(byRule, rule, entry)
+	}
+
+	sorted := func(totals map[string]*savingsTotal) []savingsTotal {
+		list := make([]savingsTotal, 0, len(totals))
+		for _, total := range totals {
+			list = append(list, *total)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Bytes != list[j].Bytes {
+				return list[i].Bytes > list[j].Bytes
+			}
+			return list[i].Name < list[j].Name
+		})
+		return list
+	}
+	report.ByDirectory = sorted(byDir)
+	report.ByRule = sorted(byRule)
+	return report
+}
+
+// showSavings prints the space reclaimed by dirmon's cleanup actions since
+// the given time (the zero time for all history)
+func showSavings(since time.Time, jsonOutput bool) error {
+	entries, err := loadSavings(since)
+	if err != nil {
+		return err
+	}
+
+	report := summarizeSavings(entries)
+	if !since.IsZero() {
+		report.Since = &since
+	}
+	if jsonOutput {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No space reclaimed yet")
+		return nil
+	}
+
+	printTotals := func(title, column string, totals []savingsTotal) {
+		fmt.Printf("\n%s:\n", title)
+		fmt.Println(strings.Repeat("-", 100))
+		fmt.Printf("%-50s %-8s %-10s %-12s %s\n", column, "ACTIONS", "FILES", "RECLAIMED", "LAST")
+		fmt.Println(strings.Repeat("-", 100))
+		for _, total := range totals {
+			fmt.Printf("%-50s %-8d %-10d %-12s %s\n", truncateString(total.Name, 50),
+				total.Actions, total.Files, formatSize(total.Bytes), total.Last.Format("2006-01-02 15:04"))
+		}
+	}
+
+	if since.IsZero() {
+		fmt.Println("Space reclaimed by dirmon")
+	} else {
+		fmt.Printf("Space reclaimed by dirmon since %s\n", since.Format("2006-01-02"))
+	}
+	printTotals("By directory", "DIRECTORY", report.ByDirectory)
+	printTotals("By rule", "RULE", report.ByRule)
+
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("Total reclaimed: %s in %d files\n", formatSize(report.Bytes), report.Files)
+	return nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// server exposes dirmon's scans, monitored directories and recorded events
+// over HTTP
+type server struct {
+	jobs    *jobManager
+	eventDB string
+
+	// tokens are the API tokens accepted by the server; with none
+	// configured the API is open
+	tokens []APIToken
+
+	// configMu serializes changes to the monitored directories
+	configMu sync.Mutex
+}
+
+// runServer starts the HTTP API on addr and blocks until it fails
+func runServer(addr, eventDB string) error {
+	srv := &server{jobs: newJobManager(), eventDB: eventDB, tokens: appConfig.APITokens}
+	if len(srv.tokens) == 0 {
+		fmt.Println("[WARNING] No API tokens configured, anyone who can reach the server has full access")
+	}
+
+	httpServer := &http.Server{Addr: addr, Handler: srv.routes()}
+
+	// Stop gracefully on Ctrl+C, so that telemetry is flushed on the way out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving dirmon API on http://%s (Press Ctrl+C to stop)\n", addr)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	fmt.Println("\nServer stopped")
+	return nil
+}
+
+// routes registers the API endpoints
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs", s.authorize(scopeRead, s.handleListJobs))
+	mux.HandleFunc("POST /jobs", s.authorize(scopeScan, s.handleStartJob))
+	mux.HandleFunc("GET /jobs/{id}", s.authorize(scopeRead, s.handleGetJob))
+	mux.HandleFunc("GET /jobs/{id}/result", s.authorize(scopeRead, s.handleJobResult))
+	mux.HandleFunc("DELETE /jobs/{id}", s.authorize(scopeScan, s.handleCancelJob))
+	mux.HandleFunc("GET /dirs", s.authorize(scopeRead, s.handleListDirs))
+	mux.HandleFunc("POST /dirs", s.authorize(scopeAdmin, s.handleAddDir))
+	mux.HandleFunc("DELETE /dirs", s.authorize(scopeAdmin, s.handleRemoveDir))
+	mux.HandleFunc("GET /events", s.authorize(scopeRead, s.handleListEvents))
+	mux.HandleFunc("POST /events/query", s.authorize(scopeRead, s.handleQueryEvents))
+	return mux
+}
+
+// authorize wraps a handler so it only runs for requests carrying a token
+// with at least the given scope
+func (s *server) authorize(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.allowed(w, r, scope) {
+			next(w, r)
+		}
+	}
+}
+
+// allowed reports whether the request carries a token with at least the
+// given scope, writing an error response if it doesn't
+func (s *server) allowed(w http.ResponseWriter, r *http.Request, scope string) bool {
+	if len(s.tokens) == 0 {
+		return true
+	}
+
+	token, ok := findToken(s.tokens, bearerToken(r))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid API token"))
+		return false
+	}
+	if !token.allows(scope) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("token %s has %s scope, %s is required", token.Name, token.Scope, scope))
+		return false
+	}
+	return true
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.List())
+}
+
+// jobRequest is the body of POST /jobs
+type jobRequest struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+func (s *server) handleStartJob(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("path is required"))
+		return
+	}
+
+	job, err := s.jobs.Start(req.Type, req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	result, job, ok := s.jobs.Result(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	if job.State != jobDone {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", job.State))
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.Cancel(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *server) handleListDirs(w http.ResponseWriter, r *http.Request) {
+	s.configMu.Lock()
+	dirs := append([]string{}, appConfig.MonitoredDirs...)
+	s.configMu.Unlock()
+	writeJSON(w, http.StatusOK, dirs)
+}
+
+// dirRequest is the body of POST /dirs
+type dirRequest struct {
+	Path string `json:"path"`
+}
+
+func (s *server) handleAddDir(w http.ResponseWriter, r *http.Request) {
+	var req dirRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("path is required"))
+		return
+	}
+
+	s.configMu.Lock()
+	absPath, added, err := addMonitoredDir(req.Path)
+	s.configMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, dirRequest{Path: absPath})
+}
+
+func (s *server) handleRemoveDir(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("path is required"))
+		return
+	}
+
+	s.configMu.Lock()
+	removed, err := removeMonitoredDir(path)
+	s.configMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not monitored", path))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := eventFilter{
+		Root:  query.Get("root"),
+		Op:    query.Get("op"),
+		Limit: 100,
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid since: %v", err))
+			return
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", limit))
+			return
+		}
+		filter.Limit = n
+	}
+
+	db, err := openEventDBReadOnly(s.eventDB)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	defer db.Close()
+
+	events, err := listEvents(db, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// queryRequest is the body of POST /events/query
+type queryRequest struct {
+	Query string `json:"query"`
+}
+
+// handleQueryEvents runs an SQL query on a read-only connection, so remote
+// clients can inspect the event database but not modify it. Only single
+// SELECT statements over the events table are accepted, as the database may
+// also hold dirmon's state (the SQLite state store, with token hashes and
+// hook output).
+func (s *server) handleQueryEvents(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("query is required"))
+		return
+	}
+
+	db, err := openEventDBReadOnly(s.eventDB)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	defer db.Close()
+
+	if err := checkEventQuery(db, req.Query); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := runEventQuery(db, req.Query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
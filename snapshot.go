@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// minGrowthBaseline is the smallest previous size for which percentage
+// growth is alerted on, so tiny directories don't trigger on a few bytes
+const minGrowthBaseline = 1024 * 1024
+
+// sizeSnapshot is a sample of the sizes in a directory tree. Paths are
+// relative to the root, and directory sizes include their subdirectories.
+type sizeSnapshot struct {
+	Root  string           `json:"root"`
+	Time  time.Time        `json:"time"`
+	Dirs  map[string]int64 `json:"dirs"`
+	Files map[string]int64 `json:"files"`
+}
+
+// growthThresholds configures when growth between snapshots is alerted on.
+// Zero values disable the respective check.
+type growthThresholds struct {
+	GB      float64
+	Percent float64
+}
+
+// takeSnapshot walks a directory tree and samples its sizes
+func takeSnapshot(absPath string) (*sizeSnapshot, error) {
+	snapshot := &sizeSnapshot{
+		Root:  absPath,
+		Time:  time.Now(),
+		Dirs:  map[string]int64{".": 0},
+		Files: make(map[string]int64),
+	}
+	ignore := newIgnoreMatcher(absPath)
+
+	err := filepath.Walk(absPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if filePath == absPath {
+				// A missing or unreadable root must not be saved as empty
+				return err
+			}
+			return nil // Skip files we can't access
+		}
+		if filePath == absPath {
+			return nil
+		}
+
+		if ignore.Match(filePath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(absPath, filePath)
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			snapshot.Dirs[rel] += 0
+			return nil
+		}
+
+		snapshot.Files[rel] = info.Size()
+		for dir := filepath.Dir(rel); ; dir = filepath.Dir(dir) {
+			snapshot.Dirs[dir] += info.Size()
+			if dir == "." {
+				break
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// snapshotPrefix returns the key prefix of the snapshots of a root
+func snapshotPrefix(root string) string {
+	sum := md5.Sum([]byte(root))
+	return filepath.Base(root) + "-" + hex.EncodeToString(sum[:4]) + "/"
+}
+
+// save stores the snapshot as compressed JSON
+func (s *sizeSnapshot) save() error {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(s); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	// Nanoseconds keep snapshots taken within the same second apart
+	return state.Put("snapshots", snapshotPrefix(s.Root)+s.Time.Format("20060102-150405.000000000")+".json.gz", buf.Bytes())
+}
+
+// listSnapshots returns the snapshot keys of a root, oldest first
+func listSnapshots(root string) ([]string, error) {
+	items, err := state.List("snapshots")
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys sort by snapshot time
+	var keys []string
+	prefix := snapshotPrefix(root)
+	for _, item := range items {
+		if strings.HasPrefix(item.Key, prefix) {
+			keys = append(keys, item.Key)
+		}
+	}
+	return keys, nil
+}
+
+// loadSnapshot reads a stored snapshot
+func loadSnapshot(key string) (*sizeSnapshot, error) {
+	data, err := state.Get("snapshots", key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var snapshot sizeSnapshot
+	if err := json.NewDecoder(reader).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// directoryGrowth is the change in size of one directory between snapshots
+type directoryGrowth struct {
+	dir      string
+	previous int64
+	current  int64
+}
+
+func (g directoryGrowth) growth() int64 {
+	return g.current - g.previous
+}
+
+// exceeds reports whether the growth crosses either threshold
+func (g directoryGrowth) exceeds(thresholds growthThresholds) bool {
+	growth := g.growth()
+	if growth <= 0 {
+		return false
+	}
+	if thresholds.GB > 0 && float64(growth) >= thresholds.GB*1024*1024*1024 {
+		return true
+	}
+	return thresholds.Percent > 0 && g.previous >= minGrowthBaseline &&
+		percentOf(growth, g.previous) >= thresholds.Percent
+}
+
+// compareSnapshots returns the directories that grew, largest growth first
+func compareSnapshots(previous, current *sizeSnapshot) []directoryGrowth {
+	var growths []directoryGrowth
+	for dir, size := range current.Dirs {
+		g := directoryGrowth{dir: dir, previous: previous.Dirs[dir], current: size}
+		if g.growth() > 0 {
+			growths = append(growths, g)
+		}
+	}
+
+	sort.Slice(growths, func(i, j int) bool {
+		if growths[i].growth() != growths[j].growth() {
+			return growths[i].growth() > growths[j].growth()
+		}
+		return growths[i].dir < growths[j].dir
+	})
+	return growths
+}
+
+// topNewFiles returns the files below dir that are new or grew the most
+// between two snapshots, up to limit entries
+func topNewFiles(previous, current *sizeSnapshot, dir string, limit int) []directoryGrowth {
+	var files []directoryGrowth
+	for file, size := range current.Files {
+		if dir != "." && !isAncestorPath(dir, file) {
+			continue
+		}
+
+		g := directoryGrowth{dir: file, previous: previous.Files[file], current: size}
+		if g.growth() > 0 {
+			files = append(files, g)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].growth() > files[j].growth()
+	})
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	return files
+}
+
+// snapshotDirectory samples a directory, compares the sample with the
+// previous one and alerts on directories that grew beyond the thresholds
+func snapshotDirectory(path string, thresholds growthThresholds) (int, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+
+	snapshots, err := listSnapshots(absPath)
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := takeSnapshot(absPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := current.save(); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Snapshot of %s saved (%d files, %s)\n",
+		absPath, len(current.Files), formatSize(current.Dirs["."]))
+
+	if len(snapshots) == 0 {
+		fmt.Println("No previous snapshot to compare with")
+		return 0, nil
+	}
+
+	previous, err := loadSnapshot(snapshots[len(snapshots)-1])
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Compared with snapshot from %s: %s -> %s\n",
+		previous.Time.Format("2006-01-02 15:04:05"),
+		formatSize(previous.Dirs["."]), formatSize(current.Dirs["."]))
+	fmt.Println(strings.Repeat("-", 80))
+
+	growths := compareSnapshots(previous, current)
+	if len(growths) == 0 {
+		fmt.Println("No directory grew since the last snapshot.")
+		return 0, nil
+	}
+
+	alerts := 0
+	for i, g := range growths {
+		alert := g.exceeds(thresholds)
+		if !alert && i >= 10 {
+			continue
+		}
+
+		label := "     "
+		if alert {
+			label = "ALERT"
+			alerts++
+		}
+
+		dir := g.dir
+		if dir == "." {
+			dir = "[root directory]"
+		}
+		fmt.Printf("%s %-45s grew by %s (%s -> %s)\n", label, truncateString(dir, 45),
+			formatSize(g.growth()), formatSize(g.previous), formatSize(g.current))
+
+		if alert {
+			for _, file := range topNewFiles(previous, current, g.dir, 5) {
+				status := "new"
+				if file.previous > 0 {
+					status = "grown"
+				}
+				fmt.Printf("        %-6s +%-12s %s\n", status, formatSize(file.growth()), file.dir)
+			}
+		}
+	}
+
+	return alerts, nil
+}
+
+// snapshotDirectories snapshots each path and returns an error if any
+// directory crossed the growth thresholds, so the command can alert from cron
+func snapshotDirectories(paths []string, thresholds growthThresholds) error {
+	total, failed := 0, 0
+	for i, path := range paths {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		alerts, err := snapshotDirectory(path, thresholds)
+		if err != nil {
+			fmt.Printf("Error snapshotting %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		total += alerts
+	}
+
+	switch {
+	case failed > 0 && total > 0:
+		return fmt.Errorf("%d directories could not be snapshotted and %d grew beyond the alert threshold", failed, total)
+	case failed > 0:
+		return fmt.Errorf("%d directories could not be snapshotted", failed)
+	case total > 0:
+		return fmt.Errorf("%d directories grew beyond the alert threshold", total)
+	}
+	return nil
+}
+
+// pruneSnapshots deletes snapshots older than the global max age, always
+// keeping the latest snapshot of each root so growth can still be compared
+func pruneSnapshots() (int64, error) {
+	cutoff := appConfig.Retention.cutoff(time.Now())
+	if cutoff.IsZero() {
+		return 0, nil
+	}
+
+	items, err := state.List("snapshots")
+	if err != nil {
+		return 0, err
+	}
+
+	// Items sort by root, then by snapshot time
+	var removed int64
+	for i, item := range items {
+		root := path.Dir(item.Key)
+		if i == len(items)-1 || path.Dir(items[i+1].Key) != root {
+			continue // Latest of its root
+		}
+		if !item.Updated.Before(cutoff) {
+			continue
+		}
+		if state.Delete("snapshots", item.Key) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
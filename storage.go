@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateStore keeps dirmon's own state: the configuration, scan checkpoints,
+// size snapshots, pack manifests and the audit and savings logs. Items are
+// grouped in collections and addressed by keys, which may contain slashes.
+//
+// The store is chosen with DIRMON_STATE: by default everything is kept in
+// files below ~/.dirmon, while "sqlite:FILE" keeps it, together with the
+// event history, in a single database that can be backed up atomically.
+type stateStore interface {
+	// Name describes where the state is kept
+	Name() string
+
+	// Dir is where files that don't belong in the store are kept, such as
+	// pack archives and tracking repositories
+	Dir() string
+
+	// EventDBPath is the event database used when none is given
+	EventDBPath() string
+
+	// Get returns an item, or an error matching fs.ErrNotExist
+	Get(collection, key string) ([]byte, error)
+
+	// Put creates or atomically replaces an item
+	Put(collection, key string, data []byte) error
+
+	// Delete removes an item; removing a missing item is not an error
+	Delete(collection, key string) error
+
+	// List returns the items of a collection, sorted by key
+	List(collection string) ([]stateItem, error)
+
+	// Append adds a record to a log
+	Append(log string, record []byte) error
+
+	// ReadLog calls fn for every record of a log, oldest first
+	ReadLog(log string, fn func(record []byte)) error
+
+	// PruneLog removes the records of a log that keep rejects and returns
+	// how many were removed
+	PruneLog(log string, keep func(record []byte) bool) (int64, error)
+
+	Close() error
+}
+
+// stateItem describes an item of a collection
+type stateItem struct {
+	Key     string
+	Size    int64
+	Updated time.Time
+}
+
+// stateCollections are the collections dirmon uses, with the suffix of
+// their files in the file store
+var stateCollections = map[string]string{
+	"config":      ".json",
+	"checkpoints": ".json",
+	"snapshots":   ".json.gz",
+	"packs":       ".json",
+}
+
+// stateCollectionNames returns the collections in a stable order
+func stateCollectionNames() []string {
+	collections := make([]string, 0, len(stateCollections))
+	for collection := range stateCollections {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// stateLogs are the logs dirmon appends to
+var stateLogs = []string{"audit", "savings"}
+
+// state is the store opened at startup
+var state stateStore
+
+// openStateStore opens the store described by spec: "" for the default file
+// store, "file:DIR" for a file store in DIR, or "sqlite:FILE"
+func openStateStore(spec string) (stateStore, error) {
+	switch {
+	case spec == "":
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			homeDir = "."
+		}
+
+		// A system-wide configuration in /opt takes precedence
+		configPath := "/opt/dirmon_config.json"
+		if _, err := os.Stat(configPath); err != nil {
+			configPath = filepath.Join(homeDir, ".dirmon_config.json")
+		}
+		return &fileStore{dir: filepath.Join(homeDir, ".dirmon"), configPath: configPath}, nil
+
+	case strings.HasPrefix(spec, "file:"):
+		dir, err := filepath.Abs(strings.TrimPrefix(spec, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		return &fileStore{dir: dir, configPath: filepath.Join(dir, "dirmon_config.json")}, nil
+
+	case strings.HasPrefix(spec, "sqlite:"):
+		path, err := filepath.Abs(strings.TrimPrefix(spec, "sqlite:"))
+		if err != nil {
+			return nil, err
+		}
+		return openSQLiteStore(path)
+	}
+	return nil, fmt.Errorf("invalid state store %q, use file:DIR or sqlite:FILE", spec)
+}
+
+// fileStore keeps state in files below a directory, with the configuration
+// in its own file
+type fileStore struct {
+	dir        string
+	configPath string
+}
+
+func (s *fileStore) Name() string {
+	return s.dir
+}
+
+func (s *fileStore) Dir() string {
+	return s.dir
+}
+
+func (s *fileStore) EventDBPath() string {
+	return filepath.Join(s.dir, "events.db")
+}
+
+// path returns the file holding an item
+func (s *fileStore) path(collection, key string) (string, error) {
+	if _, ok := stateCollections[collection]; !ok {
+		return "", fmt.Errorf("unknown state collection %q", collection)
+	}
+	if collection == "config" {
+		return s.configPath, nil
+	}
+
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid state key %q", key)
+	}
+	return filepath.Join(s.dir, collection, rel), nil
+}
+
+func (s *fileStore) Get(collection, key string) ([]byte, error) {
+	path, err := s.path(collection, key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// Put writes to a temporary file first, so an interrupt never leaves a
+// truncated item behind
+func (s *fileStore) Put(collection, key string, data []byte) error {
+	path, err := s.path(collection, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if errors.Is(err, fs.ErrPermission) {
+		// The file may be writable when its directory isn't, like a shared
+		// /opt/dirmon_config.json, so write it in place instead
+		return os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (s *fileStore) Delete(collection, key string) error {
+	path, err := s.path(collection, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// Drop directories left empty, such as those of snapshot roots
+	if collection != "config" {
+		top := filepath.Join(s.dir, collection)
+		for dir := filepath.Dir(path); dir != top && isAncestorPath(top, dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// List only returns files with the collection's suffix, skipping temporary
+// files and the pack archives kept next to their manifests
+func (s *fileStore) List(collection string) ([]stateItem, error) {
+	suffix, ok := stateCollections[collection]
+	if !ok {
+		return nil, fmt.Errorf("unknown state collection %q", collection)
+	}
+
+	if collection == "config" {
+		info, err := os.Stat(s.configPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []stateItem{{Key: "config", Size: info.Size(), Updated: info.ModTime()}}, nil
+	}
+
+	top := filepath.Join(s.dir, collection)
+	var items []stateItem
+	err := filepath.WalkDir(top, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == top && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil // Removed while listing
+		}
+		rel, _ := filepath.Rel(top, path)
+		items = append(items, stateItem{Key: filepath.ToSlash(rel), Size: info.Size(), Updated: info.ModTime()})
+		return nil
+	})
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, err
+}
+
+// logPath returns the file of a log, e.g. ~/.dirmon/audit.log
+func (s *fileStore) logPath(log string) string {
+	return filepath.Join(s.dir, log+".log")
+}
+
+func (s *fileStore) Append(log string, record []byte) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.logPath(log), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	// A single write, so records of concurrent writers don't interleave
+	_, err = file.Write(append(record, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *fileStore) ReadLog(log string, fn func(record []byte)) error {
+	file, err := os.Open(s.logPath(log))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			fn(scanner.Bytes())
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *fileStore) PruneLog(log string, keep func(record []byte) bool) (int64, error) {
+	var kept []byte
+	var removed int64
+	err := s.ReadLog(log, func(record []byte) {
+		if keep(record) {
+			kept = append(append(kept, record...), '\n')
+		} else {
+			removed++
+		}
+	})
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+
+	path := s.logPath(log)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept, 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
+
+// stateSchema creates the tables of the SQLite store, next to the events
+// table of the event database
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS state (
+	collection TEXT NOT NULL,
+	key        TEXT NOT NULL,
+	data       BLOB NOT NULL,
+	updated    TEXT NOT NULL,
+	PRIMARY KEY (collection, key)
+);
+CREATE TABLE IF NOT EXISTS state_logs (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	log    TEXT NOT NULL,
+	record BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_state_logs_log ON state_logs(log, id);
+`
+
+// sqliteStore keeps state and the event history in one SQLite database
+type sqliteStore struct {
+	path string
+	db   *sql.DB
+}
+
+// openSQLiteStore opens or creates an SQLite state database
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := openEventDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// One connection, so the pragmas set on it apply to every statement
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(stateSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{path: path, db: db}, nil
+}
+
+func (s *sqliteStore) Name() string {
+	return "sqlite:" + s.path
+}
+
+func (s *sqliteStore) Dir() string {
+	return filepath.Dir(s.path)
+}
+
+func (s *sqliteStore) EventDBPath() string {
+	return s.path
+}
+
+func (s *sqliteStore) Get(collection, key string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM state WHERE collection = ? AND key = ?", collection, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s/%s: %w", collection, key, fs.ErrNotExist)
+	}
+	return data, err
+}
+
+func (s *sqliteStore) Put(collection, key string, data []byte) error {
+	if _, ok := stateCollections[collection]; !ok {
+		return fmt.Errorf("unknown state collection %q", collection)
+	}
+	_, err := s.db.Exec(`INSERT INTO state (collection, key, data, updated) VALUES (?, ?, ?, ?)
+		ON CONFLICT (collection, key) DO UPDATE SET data = excluded.data, updated = excluded.updated`,
+		collection, key, data, time.Now().UTC().Format(sqliteTimeFormat))
+	return err
+}
+
+func (s *sqliteStore) Delete(collection, key string) error {
+	_, err := s.db.Exec("DELETE FROM state WHERE collection = ? AND key = ?", collection, key)
+	return err
+}
+
+func (s *sqliteStore) List(collection string) ([]stateItem, error) {
+	rows, err := s.db.Query(`SELECT key, length(data), updated FROM state
+		WHERE collection = ? ORDER BY key`, collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []stateItem
+	for rows.Next() {
+		var item stateItem
+		var updated string
+		if err := rows.Scan(&item.Key, &item.Size, &updated); err != nil {
+			return nil, err
+		}
+		item.Updated, _ = time.Parse(sqliteTimeFormat, updated)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *sqliteStore) Append(log string, record []byte) error {
+	_, err := s.db.Exec("INSERT INTO state_logs (log, record) VALUES (?, ?)", log, record)
+	return err
+}
+
+func (s *sqliteStore) ReadLog(log string, fn func(record []byte)) error {
+	rows, err := s.db.Query("SELECT record FROM state_logs WHERE log = ? ORDER BY id", log)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record []byte
+		if err := rows.Scan(&record); err != nil {
+			return err
+		}
+		fn(record)
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) PruneLog(log string, keep func(record []byte) bool) (int64, error) {
+	// Collect the IDs first, the store has a single connection
+	var expired []int64
+	rows, err := s.db.Query("SELECT id, record FROM state_logs WHERE log = ? ORDER BY id", log)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id int64
+		var record []byte
+		if err := rows.Scan(&id, &record); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !keep(record) {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, id := range expired {
+		if _, err := tx.Exec("DELETE FROM state_logs WHERE id = ?", id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(expired)), tx.Commit()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// backupState writes a consistent copy of the SQLite store, including the
+// event history, to a new database file
+func backupState(dest string) error {
+	store, ok := state.(*sqliteStore)
+	if !ok {
+		return fmt.Errorf("backups need the SQLite store, move the state into one first with: dirmon state migrate --to sqlite:FILE")
+	}
+
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(absDest); err == nil {
+		return fmt.Errorf("%s already exists", absDest)
+	}
+
+	// VACUUM INTO copies the database in a single read transaction
+	if _, err := store.db.Exec("VACUUM INTO ?", absDest); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(absDest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %s to %s (%s)\n", store.path, absDest, formatSize(info.Size()))
+	return nil
+}
+
+// migrateState copies the current state, logs and event history into the
+// store described by spec, which must not hold any state yet
+func migrateState(spec string) error {
+	target, err := openStateStore(spec)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	if target.Name() == state.Name() {
+		return fmt.Errorf("the state is already kept in %s", target.Name())
+	}
+
+	collections := stateCollectionNames()
+	for _, collection := range collections {
+		if items, err := target.List(collection); err != nil {
+			return err
+		} else if len(items) > 0 {
+			return fmt.Errorf("%s already holds state (%s), refusing to mix it", target.Name(), collection)
+		}
+	}
+
+	fmt.Printf("Copying state from %s to %s\n", state.Name(), target.Name())
+	for _, collection := range collections {
+		items, err := state.List(collection)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			data, err := state.Get(collection, item.Key)
+			if err != nil {
+				return err
+			}
+			if err := target.Put(collection, item.Key, data); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%-12s %d items\n", collection, len(items))
+	}
+
+	for _, log := range stateLogs {
+		records := 0
+		var appendErr error
+		err := state.ReadLog(log, func(record []byte) {
+			if appendErr == nil {
+				appendErr = target.Append(log, record)
+				records++
+			}
+		})
+		if err == nil {
+			err = appendErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-12s %d records\n", log, records)
+	}
+
+	events, err := copyEventHistory(state.EventDBPath(), target.EventDBPath())
+	if err != nil {
+		return fmt.Errorf("copying the event history: %v", err)
+	}
+	fmt.Printf("%-12s %d events\n", "events", events)
+
+	fmt.Printf("\nUse the new store with: export DIRMON_STATE=%s\n", spec)
+	fmt.Printf("The old state in %s was left in place.\n", state.Name())
+	return nil
+}
+
+// copyEventHistory appends the events of one event database to another
+func copyEventHistory(from, to string) (int64, error) {
+	if _, err := os.Stat(from); errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+
+	// Opening the source brings its schema up to date
+	source, err := openEventDB(from)
+	if err != nil {
+		return 0, err
+	}
+	source.Close()
+
+	db, err := openEventDB(to)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("ATTACH DATABASE ? AS source", from); err != nil {
+		return 0, err
+	}
+	result, err := db.Exec(`INSERT INTO events (time, root, path, dir, name, ext, op, detail, size, user, process)
+		SELECT time, root, path, dir, name, ext, op, detail, size, user, process FROM source.events ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// showStateInfo prints where the state is kept and what it holds
+func showStateInfo() error {
+	fmt.Printf("State store: %s\n", state.Name())
+	fmt.Printf("Event database: %s\n", state.EventDBPath())
+	fmt.Printf("Files (pack archives, tracking repositories): %s\n", state.Dir())
+	fmt.Println(strings.Repeat("-", 50))
+	fmt.Printf("%-15s %-10s %s\n", "COLLECTION", "ITEMS", "SIZE")
+	fmt.Println(strings.Repeat("-", 50))
+
+	for _, collection := range stateCollectionNames() {
+		items, err := state.List(collection)
+		if err != nil {
+			return err
+		}
+		var size int64
+		for _, item := range items {
+			size += item.Size
+		}
+		fmt.Printf("%-15s %-10d %s\n", collection, len(items), formatSize(size))
+	}
+
+	for _, log := range stateLogs {
+		records := 0
+		if err := state.ReadLog(log, func([]byte) { records++ }); err != nil {
+			return err
+		}
+		fmt.Printf("%-15s %-10d records\n", log+" log", records)
+	}
+	return nil
+}
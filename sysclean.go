@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cleanLocation is a standard cache or temp location known to system-clean
+type cleanLocation struct {
+	Name string
+	Path string
+
+	// MinAge only considers files not modified or accessed for this long,
+	// so that files of running programs in shared temp dirs are left alone
+	MinAge time.Duration
+
+	// Exclude lists subdirectories handled by other locations (browser
+	// caches inside the general cache directory)
+	Exclude []string
+}
+
+// cleanCandidate is a location together with what could be removed from it
+type cleanCandidate struct {
+	location cleanLocation
+	files    []string
+	size     int64
+}
+
+// systemCleanLocations returns the cache and temp locations for the current
+// OS. Browser caches are only included when requested.
+func systemCleanLocations(includeBrowsers bool, tmpAge time.Duration) []cleanLocation {
+	homeDir, _ := os.UserHomeDir()
+
+	var locations, browsers []cleanLocation
+	switch runtime.GOOS {
+	case "windows":
+		localAppData := os.Getenv("LOCALAPPDATA")
+		locations = []cleanLocation{
+			{Name: "User temp", Path: os.TempDir(), MinAge: tmpAge},
+			{Name: "Windows temp", Path: filepath.Join(os.Getenv("SystemRoot"), "Temp"), MinAge: tmpAge},
+			{Name: "Internet cache", Path: filepath.Join(localAppData, "Microsoft", "Windows", "INetCache")},
+		}
+		browsers = []cleanLocation{
+			{Name: "Chrome cache", Path: filepath.Join(localAppData, "Google", "Chrome", "User Data", "Default", "Cache")},
+			{Name: "Edge cache", Path: filepath.Join(localAppData, "Microsoft", "Edge", "User Data", "Default", "Cache")},
+			{Name: "Firefox cache", Path: filepath.Join(localAppData, "Mozilla", "Firefox", "Profiles")},
+		}
+	case "darwin":
+		caches := filepath.Join(homeDir, "Library", "Caches")
+		browsers = []cleanLocation{
+			{Name: "Chrome cache", Path: filepath.Join(caches, "Google", "Chrome")},
+			{Name: "Firefox cache", Path: filepath.Join(caches, "Firefox")},
+			{Name: "Safari cache", Path: filepath.Join(caches, "com.apple.Safari")},
+		}
+		locations = []cleanLocation{
+			{Name: "User caches", Path: caches, Exclude: locationPaths(browsers)},
+			{Name: "Temp", Path: os.TempDir(), MinAge: tmpAge},
+			{Name: "Trash", Path: filepath.Join(homeDir, ".Trash")},
+		}
+	default:
+		cacheDir := os.Getenv("XDG_CACHE_HOME")
+		if cacheDir == "" {
+			cacheDir = filepath.Join(homeDir, ".cache")
+		}
+		dataDir := os.Getenv("XDG_DATA_HOME")
+		if dataDir == "" {
+			dataDir = filepath.Join(homeDir, ".local", "share")
+		}
+
+		browsers = []cleanLocation{
+			{Name: "Chrome cache", Path: filepath.Join(cacheDir, "google-chrome")},
+			{Name: "Chromium cache", Path: filepath.Join(cacheDir, "chromium")},
+			{Name: "Firefox cache", Path: filepath.Join(cacheDir, "mozilla")},
+			{Name: "Brave cache", Path: filepath.Join(cacheDir, "BraveSoftware")},
+		}
+		locations = []cleanLocation{
+			{Name: "XDG cache", Path: cacheDir, Exclude: locationPaths(browsers)},
+			{Name: "Trash", Path: filepath.Join(dataDir, "Trash")},
+			{Name: "Temp", Path: "/tmp", MinAge: tmpAge},
+			{Name: "Var temp", Path: "/var/tmp", MinAge: tmpAge},
+		}
+	}
+
+	if includeBrowsers {
+		locations = append(locations, browsers...)
+	}
+	return locations
+}
+
+// locationPaths returns the paths of the given locations
+func locationPaths(locations []cleanLocation) []string {
+	paths := make([]string, len(locations))
+	for i, location := range locations {
+		paths[i] = location.Path
+	}
+	return paths
+}
+
+// scanCleanLocation finds the removable files of a location
+func scanCleanLocation(location cleanLocation) cleanCandidate {
+	candidate := cleanCandidate{location: location}
+	now := time.Now()
+	ignore := newIgnoreMatcher(location.Path)
+
+	filepath.Walk(location.Path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || filePath == location.Path {
+			return nil // Skip files we can't access
+		}
+
+		for _, excluded := range location.Exclude {
+			if filePath == excluded {
+				return filepath.SkipDir
+			}
+		}
+
+		if ignore.Match(filePath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if location.MinAge > 0 {
+			times := getFileTimes(filePath, info)
+			lastUsed := times.Modified
+			if times.Accessed.After(lastUsed) {
+				lastUsed = times.Accessed
+			}
+			if now.Sub(lastUsed) < location.MinAge {
+				return nil
+			}
+		}
+
+		candidate.files = append(candidate.files, filePath)
+		candidate.size += info.Size()
+		return nil
+	})
+
+	return candidate
+}
+
+// removeEmptyDirs removes directories below root left empty by cleaning,
+// deepest first; root itself is kept
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		os.Remove(dir) // Fails for directories that aren't empty
+	}
+}
+
+// systemClean reports reclaimable space in standard cache and temp locations
+// and deletes it after confirmation
+func systemClean(includeBrowsers bool, tmpAgeDays int, dryRun bool) error {
+	tmpAge := time.Duration(tmpAgeDays) * 24 * time.Hour
+	locations := systemCleanLocations(includeBrowsers, tmpAge)
+
+	fmt.Println("Reclaimable space in cache and temp locations:")
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("%-18s %-55s %-10s %s\n", "LOCATION", "PATH", "FILES", "RECLAIMABLE")
+	fmt.Println(strings.Repeat("-", 100))
+
+	var candidates []cleanCandidate
+	var total int64
+	for _, location := range locations {
+		if info, err := os.Stat(location.Path); err != nil || !info.IsDir() {
+			continue
+		}
+
+		candidate := scanCleanLocation(location)
+		name := location.Name
+		if location.MinAge > 0 {
+			name = fmt.Sprintf("%s (>%dd)", name, tmpAgeDays)
+		}
+		fmt.Printf("%-18s %-55s %-10d %s\n", truncateString(name, 18),
+			truncateString(location.Path, 55), len(candidate.files), formatSize(candidate.size))
+
+		if len(candidate.files) > 0 {
+			candidates = append(candidates, candidate)
+			total += candidate.size
+		}
+	}
+
+	fmt.Println(strings.Repeat("-", 100))
+	fmt.Printf("Total reclaimable: %s\n", formatSize(total))
+	if !includeBrowsers {
+		fmt.Println("Browser caches are not included, use --browsers to include them.")
+	}
+
+	if len(candidates) == 0 || dryRun {
+		return nil
+	}
+
+	fmt.Print("\nWould you like to delete these files? (y/N): ")
+	var response string
+	fmt.Scanln(&response)
+
+	if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+		fmt.Println("Operation cancelled")
+		return nil
+	}
+
+	var reclaimed int64
+	failed := 0
+	savings := newSavingsRecorder("system-clean")
+	defer savings.Save()
+	for _, candidate := range candidates {
+		for _, file := range candidate.files {
+			info, err := os.Lstat(file)
+			if err != nil {
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				failed++
+				continue
+			}
+			reclaimed += info.Size()
+			savings.Add(candidate.location.Path, candidate.location.Name, info.Size())
+		}
+
+		// Shared temp directories may contain other programs' empty
+		// working directories, so only tidy up caches
+		if candidate.location.MinAge == 0 {
+			removeEmptyDirs(candidate.location.Path)
+		}
+	}
+
+	fmt.Printf("Reclaimed %s\n", formatSize(reclaimed))
+	if failed > 0 {
+		fmt.Printf("%d files could not be deleted (in use or insufficient permissions)\n", failed)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies dirmon's spans and metrics
+const instrumentationName = "dirmon"
+
+// tracer and meter report to the global providers, which do nothing until
+// setupTelemetry installs exporting ones
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+)
+
+// Metric instruments, created once at startup
+var (
+	scanFilesCounter    metric.Int64Counter
+	hashedBytesCounter  metric.Int64Counter
+	eventsCounter       metric.Int64Counter
+	eventRecordDuration metric.Float64Histogram
+	jobQueueDuration    metric.Float64Histogram
+	jobDuration         metric.Float64Histogram
+	filesGauge          metric.Int64UpDownCounter
+)
+
+func init() {
+	var err, errs error
+
+	scanFilesCounter, err = meter.Int64Counter("dirmon.scan.files",
+		metric.WithDescription("Files processed by scans, by scan and phase"),
+		metric.WithUnit("{file}"))
+	errs = errors.Join(errs, err)
+
+	hashedBytesCounter, err = meter.Int64Counter("dirmon.scan.hashed_bytes",
+		metric.WithDescription("Bytes read to hash file contents"),
+		metric.WithUnit("By"))
+	errs = errors.Join(errs, err)
+
+	eventsCounter, err = meter.Int64Counter("dirmon.events",
+		metric.WithDescription("Filesystem events recorded, by operation"),
+		metric.WithUnit("{event}"))
+	errs = errors.Join(errs, err)
+
+	eventRecordDuration, err = meter.Float64Histogram("dirmon.event.record.duration",
+		metric.WithDescription("Time from receiving an event until all sinks have stored it"),
+		metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+
+	jobQueueDuration, err = meter.Float64Histogram("dirmon.job.queue.duration",
+		metric.WithDescription("Time server jobs wait for a free slot"),
+		metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+
+	jobDuration, err = meter.Float64Histogram("dirmon.job.duration",
+		metric.WithDescription("Time server jobs take to run, by type and final state"),
+		metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+
+	filesGauge, err = meter.Int64UpDownCounter("dirmon.dir.files",
+		metric.WithDescription("Files in monitored directories with a file limit, by directory"),
+		metric.WithUnit("{file}"))
+	errs = errors.Join(errs, err)
+
+	if errs != nil {
+		otel.Handle(errs)
+	}
+}
+
+// telemetryEnabled reports whether an OTLP endpoint has been configured
+// through the standard OpenTelemetry environment variables
+func telemetryEnabled() bool {
+	for _, name := range []string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT",
+	} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// setupTelemetry installs trace and metric providers exporting over OTLP/HTTP
+// when an endpoint is configured. The returned function flushes and stops
+// them; it is a no-op when telemetry is disabled.
+func setupTelemetry(ctx context.Context) (func(context.Context) error, error) {
+	if !telemetryEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Attributes from OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME take
+	// precedence over the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", instrumentationName)),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+
+	metricExporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		tracerProvider.Shutdown(ctx)
+		return nil, err
+	}
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+
+	return func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}, nil
+}
+
+// secondsSince returns the time elapsed since t in seconds, the unit of all
+// duration histograms
+func secondsSince(t time.Time) float64 {
+	return time.Since(t).Seconds()
+}
+
+// endSpan marks a span as failed if err is set and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// scanAttrs returns the metric attributes for files processed by a scan phase
+func scanAttrs(scan, phase string) metric.AddOption {
+	return metric.WithAttributes(attribute.String("scan", scan), attribute.String("phase", phase))
+}
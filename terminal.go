@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI sequences that move the cursor home and erase the display. The
+// scrollback is left alone, so earlier output can still be scrolled to.
+const ansiClearScreen = "\x1b[H\x1b[2J"
+
+// screen clears the terminal between the pages of interactive mode
+type screen struct {
+	clear bool
+}
+
+// newScreen prepares the terminal for interactive mode. Clearing is skipped
+// when it is disabled, when output is not a terminal (e.g. piped to a file)
+// and on terminals that don't understand ANSI sequences.
+func newScreen(clear bool) *screen {
+	if !clear || os.Getenv("TERM") == "dumb" || !isTerminal(os.Stdout) {
+		return &screen{}
+	}
+	return &screen{clear: enableVirtualTerminal(os.Stdout)}
+}
+
+// Clear starts a new page, either by clearing the terminal or, when
+// clearing is off, with a blank line so pages stay apart in the scrollback
+func (s *screen) Clear() {
+	if s.clear {
+		fmt.Print(ansiClearScreen)
+		return
+	}
+	fmt.Println()
+}
+
+// isTerminal reports whether a file is a terminal or console
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
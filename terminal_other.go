@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableVirtualTerminal reports whether a terminal understands ANSI
+// sequences, which terminals outside Windows always do
+func enableVirtualTerminal(file *os.File) bool {
+	return true
+}
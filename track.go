@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// trackDebounce is how long track waits for further events before
+// committing, so that a burst of writes ends up in a single commit
+const trackDebounce = 500 * time.Millisecond
+
+// gitTracker commits the state of a directory to a git repository kept in
+// dirmon's state directory, so the tracked directory itself is never touched
+type gitTracker struct {
+	gitDir  string
+	workDir string
+
+	// stateRoot is dirmon's state directory, which holds the repository
+	// and is never tracked itself, even when it is inside workDir
+	stateRoot string
+	ignore    *ignoreMatcher
+
+	// skipped are the paths reported as unreadable, so that each is
+	// reported only once
+	skipped map[string]bool
+}
+
+// newGitTracker creates (if needed) the repository tracking dir
+func newGitTracker(dir string, ignore *ignoreMatcher) (*gitTracker, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is required for tracking: %v", err)
+	}
+
+	stateRoot, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	if stateRoot, err = filepath.Abs(stateRoot); err != nil {
+		return nil, err
+	}
+	if dir == stateRoot || isAncestorPath(stateRoot, dir) {
+		return nil, fmt.Errorf("%s is inside dirmon's state directory and can't be tracked", dir)
+	}
+
+	sum := md5.Sum([]byte(dir))
+	name := filepath.Base(dir) + "-" + hex.EncodeToString(sum[:4]) + ".git"
+	tracker := &gitTracker{
+		gitDir:    filepath.Join(stateRoot, "track", name),
+		workDir:   dir,
+		stateRoot: stateRoot,
+		ignore:    ignore,
+		skipped:   make(map[string]bool),
+	}
+
+	if _, err := os.Stat(tracker.gitDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(tracker.gitDir, 0700); err != nil {
+			return nil, err
+		}
+		if _, err := tracker.git("init", "--quiet"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Exclusions are written to info/exclude before every commit instead;
+	// repositories created by older versions pointed git at the root
+	// .dirmonignore, which fails when the key isn't set
+	tracker.git("config", "--unset-all", "core.excludesFile")
+
+	return tracker, nil
+}
+
+// excludes reports whether a path is left out of tracking: ignored by a
+// .dirmonignore file or inside dirmon's state directory
+func (t *gitTracker) excludes(path string, isDir bool) bool {
+	if path == t.stateRoot || isAncestorPath(t.stateRoot, path) {
+		return true
+	}
+	return t.ignore.Match(path, isDir)
+}
+
+// writeExcludes generates the repository's info/exclude from the paths the
+// monitor ignores, dirmon's state directory and files that can't be read,
+// which are skipped and reported rather than failing the commit
+func (t *gitTracker) writeExcludes() error {
+	var patterns []string
+	err := filepath.Walk(t.workDir, func(path string, info os.FileInfo, err error) error {
+		if path == t.workDir {
+			return err
+		}
+
+		rel, relErr := filepath.Rel(t.workDir, path)
+		if relErr != nil {
+			return nil
+		}
+
+		if err != nil {
+			t.reportSkipped(rel, err)
+			patterns = append(patterns, gitExcludePattern(rel))
+			return nil
+		}
+
+		if t.excludes(path, info.IsDir()) {
+			patterns = append(patterns, gitExcludePattern(rel))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsRegular() {
+			file, err := os.Open(path)
+			if err != nil {
+				t.reportSkipped(rel, err)
+				patterns = append(patterns, gitExcludePattern(rel))
+				return nil
+			}
+			file.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	infoDir := filepath.Join(t.gitDir, "info")
+	if err := os.MkdirAll(infoDir, 0700); err != nil {
+		return err
+	}
+	content := "# Generated by dirmon track before every commit, changes are overwritten\n" +
+		strings.Join(patterns, "\n") + "\n"
+	return os.WriteFile(filepath.Join(infoDir, "exclude"), []byte(content), 0600)
+}
+
+// reportSkipped prints a path left out of tracking because it can't be read
+func (t *gitTracker) reportSkipped(rel string, err error) {
+	if t.skipped[rel] {
+		return
+	}
+	t.skipped[rel] = true
+	fmt.Printf("Skipping %s: %v\n", rel, err)
+}
+
+// gitExcludePattern returns a gitignore pattern matching exactly one path
+// relative to the repository's work tree
+func gitExcludePattern(rel string) string {
+	var sb strings.Builder
+	sb.WriteString("/")
+	for _, c := range filepath.ToSlash(rel) {
+		if strings.ContainsRune(`\*?[`, c) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	pattern := sb.String()
+
+	// Trailing spaces are trimmed unless escaped
+	if trimmed := strings.TrimRight(pattern, " "); len(trimmed) < len(pattern) {
+		pattern = trimmed + strings.Repeat(`\ `, len(pattern)-len(trimmed))
+	}
+	return pattern
+}
+
+// git runs a git command against the tracking repository
+func (t *gitTracker) git(args ...string) (string, error) {
+	hostname, _ := os.Hostname()
+	subcommand := args[0]
+	args = append([]string{
+		"--git-dir=" + t.gitDir,
+		"--work-tree=" + t.workDir,
+		"-c", "user.name=dirmon",
+		"-c", "user.email=dirmon@" + hostname,
+		"-c", "commit.gpgsign=false",
+	}, args...)
+
+	cmd := exec.Command("git", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", subcommand, err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// commit stages everything and commits it, returning the new commit's short
+// hash or an empty string if nothing changed
+func (t *gitTracker) commit(subject, body string) (string, error) {
+	if err := t.writeExcludes(); err != nil {
+		return "", err
+	}
+	if _, err := t.git("add", "--all"); err != nil {
+		return "", err
+	}
+
+	status, err := t.git("status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(status) == "" {
+		return "", nil
+	}
+
+	message := subject
+	if body != "" {
+		message += "\n\n" + body
+	}
+	if _, err := t.git("commit", "--quiet", "-m", message); err != nil {
+		return "", err
+	}
+
+	hash, err := t.git("rev-parse", "--short", "HEAD")
+	return strings.TrimSpace(hash), err
+}
+
+// trackDirectory watches a directory tree and commits every change to a git
+// repository, etckeeper-style, printing an alert for each commit
+func trackDirectory(path string, showDiff bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", absPath)
+	}
+
+	ignore := newIgnoreMatcher(absPath)
+	tracker, err := newGitTracker(absPath, ignore)
+	if err != nil {
+		return err
+	}
+
+	hash, err := tracker.commit("Snapshot of "+absPath, "")
+	if err != nil {
+		return err
+	}
+	if hash != "" {
+		fmt.Printf("Recorded current state of %s as %s\n", absPath, hash)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watchTree(watcher, absPath, tracker); err != nil {
+		return err
+	}
+
+	fmt.Printf("Tracking %s in %s\n", absPath, tracker.gitDir)
+	fmt.Printf("View history with: git --git-dir=%s --work-tree=%s log -p\n", tracker.gitDir, absPath)
+	fmt.Println("\nStarting tracking... (Press Ctrl+C to stop)")
+	fmt.Println(strings.Repeat("-", 80))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	pending := make(map[string]string)
+	timer := time.NewTimer(trackDebounce)
+	timer.Stop()
+
+	commitPending := func() {
+		if len(pending) == 0 {
+			return
+		}
+
+		subject, body := trackCommitMessage(pending)
+		pending = make(map[string]string)
+
+		hash, err := tracker.commit(subject, body)
+		if err != nil {
+			fmt.Printf("[ERROR] %v\n", err)
+			return
+		}
+		if hash == "" {
+			return
+		}
+
+		fmt.Printf("[%s] Committed %s: %s\n", time.Now().Format("15:04:05"), hash, subject)
+		format := "--stat"
+		if showDiff {
+			format = "--patch"
+		}
+		if output, err := tracker.git("show", "--format=", format, "HEAD"); err == nil {
+			fmt.Print(output)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			commitPending()
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if isIgnoredEvent(ignore, event) || tracker.excludes(event.Name, false) {
+				continue
+			}
+
+			// Watch new subdirectories as they appear
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watchTree(watcher, event.Name, tracker)
+				}
+			}
+
+			// Changes to attributes alone aren't tracked by git
+			eventType := eventTypeName(event.Op)
+			if eventType == "CHMOD" {
+				continue
+			}
+
+			rel, err := filepath.Rel(absPath, event.Name)
+			if err != nil {
+				rel = event.Name
+			}
+			pending[rel] = eventType
+			timer.Reset(trackDebounce)
+		case <-timer.C:
+			commitPending()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Printf("[ERROR] %v\n", err)
+		}
+	}
+}
+
+// watchTree adds watches for dir and every tracked directory below it
+func watchTree(watcher *fsnotify.Watcher, dir string, tracker *gitTracker) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if path != dir && tracker.excludes(path, true) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			fmt.Printf("Error watching %s: %v\n", path, err)
+		}
+		return nil
+	})
+}
+
+// trackCommitMessage summarizes a batch of changes as a commit message
+func trackCommitMessage(changes map[string]string) (string, string) {
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var body strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&body, "%s %s\n", changes[path], path)
+	}
+
+	subject := fmt.Sprintf("%s %s", changes[paths[0]], paths[0])
+	if len(paths) > 1 {
+		subject = fmt.Sprintf("%d changes: %s", len(paths), truncateString(strings.Join(paths, ", "), 60))
+	}
+	return subject, body.String()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// eventDedupWindow is how long an identical event is suppressed after it was
+// first reported, covering the copies delivered by overlapping watches
+const eventDedupWindow = 100 * time.Millisecond
+
+// watchRoots is the set of monitored directories watched together, resolved
+// to canonical paths so that nested roots and symlinked aliases are detected
+type watchRoots struct {
+	dirs      []string
+	canonical []string
+}
+
+// rootOverlap describes a monitored directory nested inside (or equal to) another
+type rootOverlap struct {
+	outer string
+	inner string
+}
+
+// newWatchRoots resolves the given monitored directories
+func newWatchRoots(dirs []string) *watchRoots {
+	roots := &watchRoots{dirs: dirs}
+	for _, dir := range dirs {
+		roots.canonical = append(roots.canonical, canonicalPath(dir))
+	}
+	return roots
+}
+
+// canonicalPath resolves symlinks in path; for paths that no longer exist
+// (e.g. deleted files) only the parent directory is resolved
+func canonicalPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	if parent, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		return filepath.Join(parent, filepath.Base(path))
+	}
+	return filepath.Clean(path)
+}
+
+// Overlaps returns every pair of roots where one contains the other
+func (r *watchRoots) Overlaps() []rootOverlap {
+	var overlaps []rootOverlap
+	for i := range r.canonical {
+		for j := range r.canonical {
+			if i == j {
+				continue
+			}
+
+			same := r.canonical[i] == r.canonical[j]
+			if (same && i < j) || isAncestorPath(r.canonical[i], r.canonical[j]) {
+				overlaps = append(overlaps, rootOverlap{outer: r.dirs[i], inner: r.dirs[j]})
+			}
+		}
+	}
+	return overlaps
+}
+
+// Attribute returns the most specific monitored directory containing path,
+// or an empty string if no root contains it
+func (r *watchRoots) Attribute(path string) string {
+	resolved := canonicalPath(path)
+
+	best := -1
+	for i, root := range r.canonical {
+		if resolved != root && !isAncestorPath(root, resolved) {
+			continue
+		}
+		if best < 0 || len(root) > len(r.canonical[best]) {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return ""
+	}
+	return r.dirs[best]
+}
+
+// watchers returns how many monitored directories report events about
+// path: watches are not recursive, so a root reports events on itself and
+// its direct entries
+func (r *watchRoots) watchers(path string) int {
+	resolved := canonicalPath(path)
+	parent := filepath.Dir(resolved)
+
+	count := 0
+	for _, root := range r.canonical {
+		if root == resolved || root == parent {
+			count++
+		}
+	}
+	return count
+}
+
+// eventDeduper drops the copies of an event delivered by overlapping
+// watches within eventDedupWindow, such as nested roots both reporting on
+// the inner one or a directory monitored under two symlinked names. Paths
+// only one watch reports on are never deduplicated, so that genuine repeated
+// changes are all kept.
+type eventDeduper struct {
+	roots *watchRoots
+	seen  map[string]time.Time
+}
+
+// newEventDeduper creates an empty deduplicator for the given roots
+func newEventDeduper(roots *watchRoots) *eventDeduper {
+	return &eventDeduper{roots: roots, seen: make(map[string]time.Time)}
+}
+
+// Duplicate reports whether the event was already seen within the window,
+// under whichever name a watch reported it
+func (d *eventDeduper) Duplicate(event fsnotify.Event) bool {
+	now := time.Now()
+	for key, seenAt := range d.seen {
+		if now.Sub(seenAt) > eventDedupWindow {
+			delete(d.seen, key)
+		}
+	}
+
+	if d.roots.watchers(event.Name) < 2 {
+		return false
+	}
+
+	key := event.Op.String() + "\x00" + canonicalPath(event.Name)
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}